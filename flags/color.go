@@ -31,6 +31,17 @@ func (c *hexColor) String() string {
 }
 
 func (c *hexColor) Set(s string) error {
+	parsed, err := ParseHexColor(s)
+	if err != nil {
+		return err
+	}
+	c.Color = parsed
+	return nil
+}
+
+// ParseHexColor parses a color as accepted by the hex color flags: 3 or 6
+// hex digits, optionally preceded by #.
+func ParseHexColor(s string) (color.Color, error) {
 	if strings.HasPrefix(s, "#") {
 		s = s[1:]
 	}
@@ -38,19 +49,18 @@ func (c *hexColor) Set(s string) error {
 		s = fmt.Sprintf("%c0%c0%c0", s[0], s[1], s[2])
 	}
 	if len(s) != 6 {
-		return fmt.Errorf("color should be 3 or 6 hex digits")
+		return nil, fmt.Errorf("color should be 3 or 6 hex digits")
 	}
 	n, err := strconv.ParseInt(s, 16, 64)
 	if err != nil {
-		return fmt.Errorf("not hexadecimal: %v", err)
+		return nil, fmt.Errorf("not hexadecimal: %v", err)
 	}
-	c.Color = &color.RGBA{
+	return &color.RGBA{
 		R: uint8(n >> 16),
 		G: uint8(n >> 8),
 		B: uint8(n),
 		A: 0xff,
-	}
-	return nil
+	}, nil
 }
 
 // HexColor defines a hex color flag with specified name, default value, and usage string.
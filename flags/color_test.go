@@ -66,3 +66,16 @@ func TestHexColor(t *testing.T) {
 	}
 
 }
+
+func TestParseHexColor(t *testing.T) {
+	c, err := ParseHexColor("#ff8000")
+	if err != nil {
+		t.Fatalf("parsing #ff8000 failed unexpectedly: %v", err)
+	}
+	if got := color.RGBAModel.Convert(c); got != (color.RGBA{255, 128, 0, 255}) {
+		t.Errorf("#ff8000 should be parsed as rgba(255, 128, 0, 255); got %v", got)
+	}
+	if _, err := ParseHexColor("#ff80"); err == nil {
+		t.Errorf("parsing #ff80 should have failed")
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"strings"
+	"time"
+)
+
+// Delay used for frames with no delay, as most browsers do.
+const defaultFrameDelay = 100 * time.Millisecond
+
+// A Player plays animated GIFs frame by frame with an Encoder, redrawing
+// each frame over the previous one. This works for protocols and
+// multiplexers that don't animate images on their own.
+type Player struct {
+	// Loops is the number of times the animation is played.
+	// Zero uses the loop count of the GIF, and negative loops forever.
+	Loops int
+	// FPS overrides the delays between frames of the GIF when positive.
+	FPS float64
+
+	enc *Encoder
+}
+
+// NewPlayer returns a player that draws frames with the given encoder.
+func NewPlayer(enc *Encoder) *Player { return &Player{enc: enc} }
+
+// Can be swapped for testing.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Play plays the animation until all loops are done or the context is
+// canceled, in which case the context error is returned.
+func (p *Player) Play(ctx context.Context, g *gif.GIF) error {
+	if len(g.Image) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+	frames := p.frames(g)
+	loops := p.loops(g)
+
+	// Reserve the space used by the frames so the saved cursor position
+	// doesn't move if the screen scrolls.
+	rows := p.enc.rows(frames[0].Bounds())
+	reserve := fmt.Sprintf("\x1b[?25l%s\x1b[%dA\x1b7", strings.Repeat("\n", rows), rows)
+	if _, err := io.WriteString(p.enc.out, reserve); err != nil {
+		return err
+	}
+	err := p.play(ctx, g, frames, loops)
+	if _, werr := io.WriteString(p.enc.out, "\x1b[?25h"); err == nil {
+		err = werr
+	}
+	return err
+}
+
+func (p *Player) play(ctx context.Context, g *gif.GIF, frames []image.Image, loops int) error {
+	// With kitty each frame is a new image, the previous one is deleted
+	// once the next one is drawn over it so they don't pile up.
+	var previous int
+	for loop := 0; loops < 0 || loop < loops; loop++ {
+		for i, frame := range frames {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(p.enc.out, "\x1b8"); err != nil {
+				return err
+			}
+			if err := p.enc.EncodeImage(frame); err != nil {
+				return err
+			}
+			if p.enc.protocol == Kitty {
+				if previous != 0 {
+					if _, err := io.WriteString(p.enc.out, p.enc.kittyDelete(previous)); err != nil {
+						return err
+					}
+				}
+				previous = *p.enc.kittyID
+			}
+			if err := sleep(ctx, p.delay(g, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loops returns how many times the animation should be played, or a
+// negative number to play it forever.
+func (p *Player) loops(g *gif.GIF) int {
+	if p.Loops != 0 {
+		return p.Loops
+	}
+	switch {
+	case g.LoopCount == 0:
+		return -1
+	case g.LoopCount < 0:
+		return 1
+	default:
+		return g.LoopCount + 1
+	}
+}
+
+func (p *Player) delay(g *gif.GIF, i int) time.Duration {
+	if p.FPS > 0 {
+		return time.Duration(float64(time.Second) / p.FPS)
+	}
+	if i >= len(g.Delay) || g.Delay[i] <= 0 {
+		return defaultFrameDelay
+	}
+	return time.Duration(g.Delay[i]) * 10 * time.Millisecond
+}
+
+// frames composes the frames of the animation, honoring their disposal
+// methods.
+func (p *Player) frames(g *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewNRGBA(bounds)
+	frames := make([]image.Image, len(g.Image))
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		m := image.NewNRGBA(bounds)
+		copy(m.Pix, canvas.Pix)
+		frames[i] = m
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// rows returns the number of rows of cells used to display an image with
+// the given bounds.
+func (enc *Encoder) rows(b image.Rectangle) int {
+	if enc.protocol == Blocks {
+		_, rows := enc.cellSize(b)
+		return rows
+	}
+	_, h := enc.pixelSize(b)
+	_, ch := enc.cellPixels()
+	return (h + ch - 1) / ch
+}
@@ -0,0 +1,164 @@
+package imgcat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	green = color.RGBA{0, 0xff, 0, 0xff}
+)
+
+// testGIF returns a 2x1 animation with a red pixel on the left, then a
+// green one on the right, and then nothing new.
+func testGIF(disposal byte) *gif.GIF {
+	p := color.Palette{color.Transparent, red, green}
+	f1 := image.NewPaletted(image.Rect(0, 0, 1, 1), p)
+	f1.SetColorIndex(0, 0, 1)
+	f2 := image.NewPaletted(image.Rect(1, 0, 2, 1), p)
+	f2.SetColorIndex(1, 0, 2)
+	f3 := image.NewPaletted(image.Rect(0, 0, 2, 1), p)
+	return &gif.GIF{
+		Image:    []*image.Paletted{f1, f2, f3},
+		Delay:    []int{10, 0, 50},
+		Disposal: []byte{disposal, disposal, disposal},
+		Config:   image.Config{Width: 2, Height: 1},
+	}
+}
+
+func TestPlayerFrames(t *testing.T) {
+	tc := []struct {
+		disposal byte
+		frames   [][2]color.Color
+	}{
+		{gif.DisposalNone, [][2]color.Color{{red, color.Transparent}, {red, green}, {red, green}}},
+		{gif.DisposalBackground, [][2]color.Color{{red, color.Transparent}, {color.Transparent, green}, {color.Transparent, color.Transparent}}},
+		{gif.DisposalPrevious, [][2]color.Color{{red, color.Transparent}, {color.Transparent, green}, {color.Transparent, color.Transparent}}},
+	}
+	for _, tt := range tc {
+		frames := NewPlayer(nil).frames(testGIF(tt.disposal))
+		for i, frame := range frames {
+			for x := 0; x < 2; x++ {
+				got := color.RGBAModel.Convert(frame.At(x, 0))
+				want := color.RGBAModel.Convert(tt.frames[i][x])
+				if got != want {
+					t.Errorf("disposal %d frame %d pixel %d: expected %v; got %v", tt.disposal, i, x, want, got)
+				}
+			}
+		}
+	}
+}
+
+func TestPlayerPlay(t *testing.T) {
+	defer func(old func(context.Context, time.Duration) error) { sleep = old }(sleep)
+	var delays []time.Duration
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	ms := time.Millisecond
+	tc := []struct {
+		name      string
+		loopCount int
+		loops     int
+		fps       float64
+		delays    []time.Duration
+	}{
+		{"once", -1, 0, 0, []time.Duration{100 * ms, 100 * ms, 500 * ms}},
+		{"loop count", 1, 0, 0, []time.Duration{100 * ms, 100 * ms, 500 * ms, 100 * ms, 100 * ms, 500 * ms}},
+		{"loops override", 0, 1, 0, []time.Duration{100 * ms, 100 * ms, 500 * ms}},
+		{"fps", -1, 0, 4, []time.Duration{250 * ms, 250 * ms, 250 * ms}},
+	}
+	for _, tt := range tc {
+		delays = nil
+		g := testGIF(gif.DisposalNone)
+		g.LoopCount = tt.loopCount
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, Blocks, Width(Cells(2)), Height(Cells(1)), PreserveAspectRatio(false))
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		p := NewPlayer(enc)
+		p.Loops, p.FPS = tt.loops, tt.fps
+		if err := p.Play(context.Background(), g); err != nil {
+			t.Fatalf("%s: could not play: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(delays, tt.delays) {
+			t.Errorf("%s: expected delays %v; got %v", tt.name, tt.delays, delays)
+		}
+
+		out := buf.String()
+		if !strings.HasPrefix(out, "\x1b[?25l\n\x1b[1A\x1b7\x1b8") || !strings.HasSuffix(out, "\x1b[?25h") {
+			t.Errorf("%s: cursor not saved, restored, or shown: %q", tt.name, out)
+		}
+		if n, want := strings.Count(out, "\x1b8"), len(tt.delays); n != want {
+			t.Errorf("%s: expected %d frames; got %d", tt.name, want, n)
+		}
+	}
+}
+
+func TestPlayerKitty(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+	defer func(old func(context.Context, time.Duration) error) { sleep = old }(sleep)
+	sleep = func(ctx context.Context, d time.Duration) error { return nil }
+
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty, Width(Cells(2)))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	p := NewPlayer(enc)
+	p.Loops = 1
+	if err := p.Play(context.Background(), testGIF(gif.DisposalNone)); err != nil {
+		t.Fatalf("could not play: %v", err)
+	}
+
+	// Every frame but the last one is deleted once the next one is drawn.
+	out := buf.String()
+	for id := 1; id <= 3; id++ {
+		if !strings.Contains(out, fmt.Sprintf("q=2,i=%d,p=1", id)) {
+			t.Errorf("frame %d not drawn", id)
+		}
+		deleted := strings.Contains(out, fmt.Sprintf("a=d,d=I,i=%d,q=2", id))
+		if want := id < 3; deleted != want {
+			t.Errorf("expected frame %d deleted to be %v; got %v", id, want, deleted)
+		}
+	}
+}
+
+func TestPlayerCancel(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Blocks)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	p := NewPlayer(enc)
+	p.Loops = -1
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Play(ctx, testGIF(gif.DisposalNone)); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded; got %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "\x1b[?25h") {
+		t.Errorf("cursor not shown after cancelation")
+	}
+}
+
+func TestPlayerNoFrames(t *testing.T) {
+	if err := NewPlayer(nil).Play(context.Background(), &gif.GIF{}); err == nil {
+		t.Errorf("expected error playing no frames")
+	}
+}
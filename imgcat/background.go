@@ -0,0 +1,97 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Background sets the color transparent images are composited over by the
+// Sixel and Blocks protocols, which can only draw pixels that are either
+// transparent or opaque. It's usually the background of the terminal, as
+// returned by QueryBackground.
+// Defaults to none, drawing the pixels that are mostly transparent as
+// transparent and the rest as opaque.
+func Background(c color.Color) Option {
+	value := "none"
+	if c != nil {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		value = fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return Option{"background", value, func(o *Options) { o.Background = c }}
+}
+
+// composite returns m drawn over the background of the encoder, if any,
+// or m itself if it's opaque.
+func (enc *Encoder) composite(m image.Image) image.Image {
+	bg := enc.opts().Background
+	if o, ok := m.(interface{ Opaque() bool }); bg == nil || (ok && o.Opaque()) {
+		return m
+	}
+	b := m.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, b, m, b.Min, draw.Over)
+	return dst
+}
+
+// queryBackground asks for the background color with OSC 11.
+const queryBackground = "\x1b]11;?\a"
+
+// backgroundResponse matches the color as rgb:RRRR/GGGG/BBBB, with one to
+// four hex digits per component, or with an alpha component as rgba.
+var backgroundResponse = regexp.MustCompile("\x1b\\]11;rgba?:([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})(?:/[0-9a-fA-F]{1,4})?(?:\a|\x1b\\\\)")
+
+// QueryBackground asks the terminal for its background color. The terminal
+// should be in raw mode, as the one returned by OpenTTY. It fails if the
+// terminal doesn't answer before the timeout expires.
+func QueryBackground(tty io.ReadWriter, timeout time.Duration) (color.Color, error) {
+	// As in Probe, the primary device attributes query goes last.
+	if _, err := io.WriteString(tty, queryBackground+queryAttribute); err != nil {
+		return nil, err
+	}
+	res := readResponses(tty, timeout, func(b []byte) bool { return attributeResponse.Match(b) })
+	m := backgroundResponse.FindSubmatch(res)
+	if m == nil {
+		return nil, fmt.Errorf("the terminal did not report its background color")
+	}
+	var c [3]uint8
+	for i, hex := range m[1:4] {
+		v, _ := strconv.ParseUint(string(hex), 16, 16) // the regexp only matches hex digits.
+		full := uint64(1)<<(4*uint(len(hex))) - 1
+		c[i] = uint8((v*255 + full/2) / full)
+	}
+	return color.RGBA{c[0], c[1], c[2], 0xff}, nil
+}
+
+// TerminalBackground opens the controlling terminal and queries its
+// background color.
+func TerminalBackground(timeout time.Duration) (color.Color, error) {
+	tty, err := OpenTTY()
+	if err != nil {
+		return nil, err
+	}
+	c, err := QueryBackground(tty, timeout)
+	if cerr := tty.Close(); err == nil {
+		err = cerr
+	}
+	return c, err
+}
@@ -0,0 +1,124 @@
+package imgcat
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryBackground(t *testing.T) {
+	tc := []struct {
+		name     string
+		response string
+		want     color.Color
+	}{
+		{"16 bits", "\x1b]11;rgb:ffff/8080/0000\a", color.RGBA{0xff, 0x80, 0, 0xff}},
+		{"8 bits", "\x1b]11;rgb:1e/1e/2e\x1b\\", color.RGBA{0x1e, 0x1e, 0x2e, 0xff}},
+		{"4 bits", "\x1b]11;rgb:f/8/0\a", color.RGBA{0xff, 0x88, 0, 0xff}},
+		{"alpha", "\x1b]11;rgba:0000/0000/ffff/8000\a", color.RGBA{0, 0, 0xff, 0xff}},
+		{"no answer", "", nil},
+		{"bad answer", "\x1b]11;rgb:ffff/ffff\a", nil},
+	}
+	for _, tt := range tc {
+		tty := newFakeTTY(map[string]string{queryBackground: tt.response, queryAttribute: "\x1b[?62;c"})
+		got, err := QueryBackground(tty, time.Second)
+		check(t, tty.Close())
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: could not query: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %v; got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestBackgroundOption(t *testing.T) {
+	tc := map[string]Option{
+		"background=none":    Background(nil),
+		"background=#ff8000": Background(color.RGBA{0xff, 0x80, 0, 0xff}),
+		"background=#ffffff": Background(color.White),
+	}
+	for want, o := range tc {
+		if got := o.String(); got != want {
+			t.Errorf("expected %s; got %s", want, got)
+		}
+	}
+}
+
+func TestComposite(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(1, 1, 3, 2))
+	m.Set(1, 1, color.NRGBA{0xff, 0, 0, 0x80})
+	m.Set(2, 1, color.NRGBA{0, 0xff, 0, 0xff})
+
+	enc := &Encoder{}
+	if got := enc.composite(m); got != image.Image(m) {
+		t.Errorf("images should not be composited without a background")
+	}
+
+	enc.options = []Option{Background(color.RGBA{0, 0, 0xff, 0xff})}
+	got := enc.composite(m)
+	if got.Bounds() != m.Bounds() {
+		t.Fatalf("composited image has bounds %v; want %v", got.Bounds(), m.Bounds())
+	}
+	for _, p := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{1, 1, color.NRGBA{0x80, 0, 0x7f, 0xff}},
+		{2, 1, color.NRGBA{0, 0xff, 0, 0xff}},
+	} {
+		if c := color.NRGBAModel.Convert(got.At(p.x, p.y)); c != p.want {
+			t.Errorf("pixel %d,%d is %v; want %v", p.x, p.y, c, p.want)
+		}
+	}
+	if opaque := image.NewRGBA(image.Rect(0, 0, 1, 1)); enc.composite(opaque) == image.Image(opaque) {
+		t.Errorf("a transparent image should be composited")
+	}
+	opaque := image.NewGray(image.Rect(0, 0, 1, 1))
+	if enc.composite(opaque) != image.Image(opaque) {
+		t.Errorf("an opaque image should not be composited")
+	}
+}
+
+func TestBlocksBackground(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 1, 2))
+	m.Set(0, 1, color.NRGBA{0xff, 0, 0, 0xff})
+
+	tc := []struct {
+		bg  color.Color
+		out string
+	}{
+		{nil, "\x1b[38;2;255;0;0m▄\x1b[0m\n"},
+		{color.RGBA{0, 0, 0xff, 0xff}, "\x1b[38;2;0;0;255m\x1b[48;2;255;0;0m▀\x1b[0m\n"},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, Blocks, Width(Cells(1)), Height(Cells(1)), PreserveAspectRatio(false), Background(tt.bg))
+		check(t, err)
+		check(t, enc.writeBlocks(m))
+		if got := buf.String(); got != tt.out {
+			t.Errorf("with background %v expected %q; got %q", tt.bg, tt.out, got)
+		}
+	}
+}
+
+func TestSixelBackground(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 6, 6))
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Sixel, Background(color.White))
+	check(t, err)
+	check(t, enc.writeSixel(m))
+	if !strings.Contains(buf.String(), ";2;100;100;100") {
+		t.Errorf("a transparent image should be drawn with the background color, got %q", buf.String())
+	}
+}
@@ -0,0 +1,618 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// Glyphs are the characters used to draw images with the Blocks protocol.
+type Glyphs string
+
+const (
+	// HalfBlocks draws two pixels per cell using the upper half block
+	// with different foreground and background colors.
+	HalfBlocks Glyphs = "half"
+	// Quadrants draws four pixels per cell with two colors.
+	Quadrants Glyphs = "quadrants"
+	// Braille draws eight dots per cell with a single color.
+	Braille Glyphs = "braille"
+	// ASCII draws a pixel per cell with a printable ASCII character,
+	// denser for lighter pixels, for logs and files that can't show
+	// anything else.
+	ASCII Glyphs = "ascii"
+	// ASCIIEdges is like ASCII, but draws the cells crossed by an edge
+	// of the image with the line |, /, -, or \ closest to its direction.
+	ASCIIEdges Glyphs = "ascii-edges"
+)
+
+// ColorDepth is the number of bits per color used by text renderers.
+type ColorDepth int
+
+const (
+	// TrueColor uses 24-bit colors.
+	TrueColor ColorDepth = 24
+	// Colors256 uses the xterm 256 colors palette.
+	Colors256 ColorDepth = 8
+	// Colors16 uses the 16 standard ANSI colors.
+	Colors16 ColorDepth = 4
+	// Monochrome uses no colors, so no escape sequences are written.
+	// The pixels lighter than the average of the image are drawn as
+	// blocks or dots, and ASCII only uses the density of characters.
+	Monochrome ColorDepth = 1
+)
+
+// GlyphSet sets the characters used by the Blocks protocol.
+// Defaults to HalfBlocks.
+func GlyphSet(g Glyphs) Option {
+	return Option{"glyphs", string(g), func(o *Options) { o.Glyphs = g }}
+}
+
+// Depth sets the colors used by the Blocks protocol.
+// Defaults to TrueColor.
+func Depth(d ColorDepth) Option {
+	return Option{"depth", fmt.Sprint(int(d)), func(o *Options) { o.Depth = d }}
+}
+
+// encodeBlocks decodes the image and draws it with unicode characters.
+func (enc *Encoder) encodeBlocks(r io.Reader) error {
+	m, _, err := DecodeImage(r)
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+	return enc.writeBlocks(m)
+}
+
+func (enc *Encoder) writeBlocks(m image.Image) error {
+	m = enc.composite(m)
+	glyphs := HalfBlocks
+	if g := enc.opts().Glyphs; g != "" {
+		glyphs = g
+	}
+	depth, err := enc.colorDepth()
+	if err != nil {
+		return err
+	}
+
+	// Pixels per cell.
+	var pw, ph int
+	switch glyphs {
+	case HalfBlocks:
+		pw, ph = 1, 2
+	case Quadrants:
+		pw, ph = 2, 2
+	case Braille:
+		pw, ph = 2, 4
+	case ASCII, ASCIIEdges:
+		pw, ph = 1, 1
+	default:
+		return fmt.Errorf("unknown glyphs %q", glyphs)
+	}
+
+	cols, rows := enc.cellSize(m.Bounds())
+	m = sample(m, cols*pw, rows*ph)
+
+	buf := new(bytes.Buffer)
+	p := &painter{buf: buf, depth: depth, threshold: meanLuminance(m)}
+	p.lo, p.hi = luminanceRange(m)
+	var edges [][]rune
+	if glyphs == ASCIIEdges {
+		cw, ch := enc.cellPixels()
+		edges = edgeLines(m, cw, ch, max(48, (p.hi-p.lo)/3))
+	}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			cell := make([]color.NRGBA, pw*ph)
+			for y := 0; y < ph; y++ {
+				for x := 0; x < pw; x++ {
+					cell[y*pw+x] = color.NRGBAModel.Convert(m.At(col*pw+x, row*ph+y)).(color.NRGBA)
+				}
+			}
+			switch glyphs {
+			case HalfBlocks:
+				p.halfBlock(cell[0], cell[1])
+			case Quadrants:
+				p.quadrant(cell)
+			case Braille:
+				p.braille(cell)
+			case ASCII:
+				p.ascii(cell[0], 0)
+			case ASCIIEdges:
+				p.ascii(cell[0], edges[row][col])
+			}
+		}
+		p.reset()
+		buf.WriteByte('\n')
+	}
+	_, err = enc.out.Write(buf.Bytes())
+	return err
+}
+
+func (enc *Encoder) colorDepth() (ColorDepth, error) {
+	switch d := enc.opts().Depth; d {
+	case 0:
+		return TrueColor, nil
+	case TrueColor, Colors256, Colors16, Monochrome:
+		return d, nil
+	default:
+		return 0, fmt.Errorf("unsupported color depth %d", d)
+	}
+}
+
+// cellSize returns the number of columns and rows used to draw an image
+// with the given bounds as text. Only lengths in cells and percentages
+// of the width are honored, the height is otherwise computed so the
+// image keeps its aspect ratio.
+func (enc *Encoder) cellSize(b image.Rectangle) (cols, rows int) {
+	iw, ih := b.Dx(), b.Dy()
+	if iw == 0 || ih == 0 {
+		return 0, 0
+	}
+	cw, ch := enc.cellPixels()
+	cols, okc := enc.columns(iw)
+	o := enc.opts()
+	rows, okr := cellsOption(string(o.Height))
+	// Number of rows preserving the aspect ratio for a number of columns.
+	fit := func(cols int) int {
+		return max(1, (cols*cw*ih/iw+ch/2)/ch)
+	}
+	switch {
+	case okc && okr:
+		if o.preserveAspectRatio() {
+			if r := fit(cols); r <= rows {
+				return cols, r
+			}
+			return max(1, cols*rows/fit(cols)), rows
+		}
+		return cols, rows
+	case okc:
+		return cols, fit(cols)
+	case okr:
+		return max(1, cols*rows/fit(cols)), rows
+	default:
+		return cols, fit(cols)
+	}
+}
+
+// columns returns the width option in cells, defaulting to the inherent
+// size of an image of the given width capped to the terminal width.
+func (enc *Encoder) columns(iw int) (int, bool) {
+	win := enc.window()
+	v := enc.opts().Width
+	if strings.HasSuffix(string(v), "%") {
+		if n, err := win.Cells(v, Horizontal); err == nil {
+			return max(1, n), true
+		}
+	}
+	if n, ok := cellsOption(string(v)); ok {
+		return n, true
+	}
+	cw, _ := win.Cell()
+	return min(win.Columns, max(1, (iw+cw-1)/cw)), false
+}
+
+// sample resizes m to the given size averaging the pixels covered by
+// each of the new pixels.
+func sample(m image.Image, w, h int) image.Image {
+	b := m.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+			var sr, sg, sb, sa, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := m.At(sx, sy).RGBA()
+					sr, sg, sb, sa, n = sr+r, sg+g, sb+b, sa+a, n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(sr / n), uint16(sg / n), uint16(sb / n), uint16(sa / n)})
+		}
+	}
+	return dst
+}
+
+// painter writes characters with ANSI colors, only emitting the escape
+// sequences when colors change.
+type painter struct {
+	buf    *bytes.Buffer
+	depth  ColorDepth
+	fg, bg string
+	// luminance above which braille dots are drawn.
+	threshold int
+	// range of luminances of the image, stretched by ASCII.
+	lo, hi int
+}
+
+func opaque(c color.NRGBA) bool { return c.A >= 0x80 }
+
+// halfBlockRunes indexed by a mask where the bits 1 and 2 are the upper
+// and lower halves.
+var halfBlockRunes = []rune(" ▀▄█")
+
+func (p *painter) halfBlock(top, bottom color.NRGBA) {
+	if p.depth == Monochrome {
+		p.paint(halfBlockRunes[p.mask([]color.NRGBA{top, bottom})], nil, nil)
+		return
+	}
+	switch {
+	case opaque(top) && opaque(bottom):
+		p.paint('▀', &top, &bottom)
+	case opaque(top):
+		p.paint('▀', &top, nil)
+	case opaque(bottom):
+		p.paint('▄', &bottom, nil)
+	default:
+		p.paint(' ', nil, nil)
+	}
+}
+
+// quadrantRunes indexed by a mask where the bits 1, 2, 4, and 8 are the
+// upper left, upper right, lower left, and lower right quadrants.
+var quadrantRunes = []rune(" ▘▝▀▖▌▞▛▗▚▐▜▄▙▟█")
+
+// quadrant draws four pixels with the two colors that represent them best.
+func (p *painter) quadrant(cell []color.NRGBA) {
+	if p.depth == Monochrome {
+		p.paint(quadrantRunes[p.mask(cell)], nil, nil)
+		return
+	}
+	bestMask, bestErr := 0, -1
+	var bestFg, bestBg color.NRGBA
+	for mask := 1; mask < 16; mask++ {
+		var fg, bg []color.NRGBA
+		for i, c := range cell {
+			if mask&(1<<uint(i)) != 0 {
+				fg = append(fg, c)
+			} else {
+				bg = append(bg, c)
+			}
+		}
+		mf, mb := mean(fg), mean(bg)
+		e := 0
+		for i, c := range cell {
+			if mask&(1<<uint(i)) != 0 {
+				e += distance(c, mf)
+			} else {
+				e += distance(c, mb)
+			}
+		}
+		if bestErr < 0 || e < bestErr {
+			bestMask, bestErr, bestFg, bestBg = mask, e, mf, mb
+		}
+	}
+	fg, bg := &bestFg, &bestBg
+	if !opaque(bestBg) {
+		bg = nil
+	}
+	if !opaque(bestFg) {
+		p.paint(' ', nil, bg)
+		return
+	}
+	p.paint(quadrantRunes[bestMask], fg, bg)
+}
+
+// brailleBits maps the position of the dots in a 2x4 cell to the bits of
+// the braille patterns block.
+var brailleBits = []rune{0x01, 0x08, 0x02, 0x10, 0x04, 0x20, 0x40, 0x80}
+
+// braille draws the opaque pixels of a 2x4 cell that are lighter than
+// the average of the image as dots.
+func (p *painter) braille(cell []color.NRGBA) {
+	var bits rune
+	var on []color.NRGBA
+	for i, c := range cell {
+		if opaque(c) && luminance(c) > p.threshold {
+			bits |= brailleBits[i]
+			on = append(on, c)
+		}
+	}
+	if bits == 0 {
+		p.paint(' ', nil, nil)
+		return
+	}
+	fg := mean(on)
+	p.paint(0x2800+bits, &fg, nil)
+}
+
+// mask returns a mask with a bit set for each opaque pixel lighter than
+// the average of the image.
+func (p *painter) mask(cell []color.NRGBA) int {
+	m := 0
+	for i, c := range cell {
+		if opaque(c) && luminance(c) > p.threshold {
+			m |= 1 << uint(i)
+		}
+	}
+	return m
+}
+
+// asciiRamp are the characters used by ASCII, from darker to lighter.
+const asciiRamp = " .:-=+*#%@"
+
+// ascii draws a pixel with the character for its luminance, or with the
+// given line if it's not zero.
+func (p *painter) ascii(c color.NRGBA, line rune) {
+	if !opaque(c) {
+		p.paint(' ', nil, nil)
+		return
+	}
+	r := rune(asciiRamp[p.level(luminance(c), len(asciiRamp))])
+	if line != 0 {
+		r = line
+	}
+	if r == ' ' {
+		p.paint(' ', nil, nil)
+		return
+	}
+	p.paint(r, &c, nil)
+}
+
+// level returns which of n levels a luminance is in, stretching the range
+// of luminances of the image so it uses all of them.
+func (p *painter) level(l, n int) int {
+	lo, hi := p.lo, p.hi
+	if hi <= lo {
+		lo, hi = 0, 255
+	}
+	return min(n-1, max(0, (l-lo)*n/(hi-lo+1)))
+}
+
+// edgeLines returns the line drawn by ASCIIEdges for each pixel of m, in
+// cells of the given size, or zero where there's no edge. Edges are found
+// with the Sobel operator where the difference of luminance is above the
+// threshold, only keeping the pixels where it's largest across the edge
+// so lines are thin.
+func edgeLines(m image.Image, cw, ch, threshold int) [][]rune {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Transparent pixels are darker than any opaque one, so the outlines
+	// of the opaque parts of the image are edges.
+	l := make([][]float64, h)
+	for y := range l {
+		l[y] = make([]float64, w)
+		for x := range l[y] {
+			if c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA); opaque(c) {
+				l[y][x] = float64(256 + luminance(c))
+			}
+		}
+	}
+	at := func(x, y int) float64 { return l[min(h-1, max(0, y))][min(w-1, max(0, x))] }
+
+	// Magnitude of the gradient, and the index in lines of the line
+	// perpendicular to it.
+	const lines = `|/-\`
+	mag := make([][]float64, h)
+	dir := make([][]int, h)
+	for y := 0; y < h; y++ {
+		mag[y], dir[y] = make([]float64, w), make([]int, w)
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+			mag[y][x] = math.Max(math.Abs(gx), math.Abs(gy)) / 4
+			// Cells are not square, so the differences are divided by
+			// the distance between their centers.
+			a := math.Atan2(gy/float64(ch), gx/float64(cw)) * 180 / math.Pi
+			if a < 0 {
+				a += 180
+			}
+			dir[y][x] = int((a+22.5)/45) % 4
+		}
+	}
+
+	// Neighbors across the edge, for each line.
+	across := []image.Point{{1, 0}, {1, 1}, {0, 1}, {-1, 1}}
+	// wins reports whether the pixel at x, y is kept over the one at x2, y2,
+	// which on ties is the lighter one.
+	wins := func(x, y, x2, y2 int) bool {
+		if x2 < 0 || y2 < 0 || x2 >= w || y2 >= h {
+			return true
+		}
+		if mag[y][x] != mag[y2][x2] {
+			return mag[y][x] > mag[y2][x2]
+		}
+		return l[y][x] >= l[y2][x2]
+	}
+	edges := make([][]rune, h)
+	for y := 0; y < h; y++ {
+		edges[y] = make([]rune, w)
+		for x := 0; x < w; x++ {
+			d := across[dir[y][x]]
+			if mag[y][x] >= float64(threshold) && wins(x, y, x-d.X, y-d.Y) && wins(x, y, x+d.X, y+d.Y) {
+				edges[y][x] = rune(lines[dir[y][x]])
+			}
+		}
+	}
+	return edges
+}
+
+func mean(cs []color.NRGBA) color.NRGBA {
+	if len(cs) == 0 {
+		return color.NRGBA{}
+	}
+	var r, g, b, a int
+	for _, c := range cs {
+		r, g, b, a = r+int(c.R), g+int(c.G), b+int(c.B), a+int(c.A)
+	}
+	n := len(cs)
+	return color.NRGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)}
+}
+
+func distance(a, b color.NRGBA) int {
+	dr, dg, db, da := int(a.R)-int(b.R), int(a.G)-int(b.G), int(a.B)-int(b.B), int(a.A)-int(b.A)
+	return dr*dr + dg*dg + db*db + da*da
+}
+
+// meanLuminance returns the average luminance of the opaque pixels of m.
+func meanLuminance(m image.Image) int {
+	b := m.Bounds()
+	sum, n := 0, 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if opaque(c) {
+				sum, n = sum+luminance(c), n+1
+			}
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / n
+}
+
+// luminanceRange returns the lowest and highest luminance of the opaque
+// pixels of m.
+func luminanceRange(m image.Image) (lo, hi int) {
+	b := m.Bounds()
+	lo, hi = 255, 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if opaque(c) {
+				l := luminance(c)
+				lo, hi = min(lo, l), max(hi, l)
+			}
+		}
+	}
+	return lo, hi
+}
+
+func luminance(c color.NRGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
+
+// paint writes a character with the given colors, nil meaning the
+// default color of the terminal.
+func (p *painter) paint(r rune, fg, bg *color.NRGBA) {
+	if p.depth == Monochrome {
+		fg, bg = nil, nil
+	}
+	nfg, nbg := "", ""
+	if fg != nil {
+		nfg = p.sgr(*fg, false)
+	}
+	if bg != nil {
+		nbg = p.sgr(*bg, true)
+	}
+	if (p.fg != "" && nfg == "") || (p.bg != "" && nbg == "") {
+		p.reset()
+	}
+	if nfg != p.fg {
+		p.buf.WriteString(nfg)
+	}
+	if nbg != p.bg {
+		p.buf.WriteString(nbg)
+	}
+	p.fg, p.bg = nfg, nbg
+	p.buf.WriteRune(r)
+}
+
+func (p *painter) reset() {
+	if p.fg != "" || p.bg != "" {
+		p.buf.WriteString("\x1b[0m")
+	}
+	p.fg, p.bg = "", ""
+}
+
+// sgr returns the escape sequence setting the given foreground or
+// background color.
+func (p *painter) sgr(c color.NRGBA, bg bool) string {
+	switch p.depth {
+	case Colors256:
+		if bg {
+			return fmt.Sprintf("\x1b[48;5;%dm", xterm256(c))
+		}
+		return fmt.Sprintf("\x1b[38;5;%dm", xterm256(c))
+	case Colors16:
+		n := ansi16.Index(color.RGBA{c.R, c.G, c.B, 0xff})
+		code := 30 + n
+		if n >= 8 {
+			code = 90 + n - 8
+		}
+		if bg {
+			code += 10
+		}
+		return fmt.Sprintf("\x1b[%dm", code)
+	default:
+		if bg {
+			return fmt.Sprintf("\x1b[48;2;%d;%d;%dm", c.R, c.G, c.B)
+		}
+		return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.R, c.G, c.B)
+	}
+}
+
+// ansi16 are the colors of the 16 standard ANSI colors as in xterm.
+var ansi16 = color.Palette{
+	color.RGBA{0x00, 0x00, 0x00, 0xff}, color.RGBA{0xcd, 0x00, 0x00, 0xff},
+	color.RGBA{0x00, 0xcd, 0x00, 0xff}, color.RGBA{0xcd, 0xcd, 0x00, 0xff},
+	color.RGBA{0x00, 0x00, 0xee, 0xff}, color.RGBA{0xcd, 0x00, 0xcd, 0xff},
+	color.RGBA{0x00, 0xcd, 0xcd, 0xff}, color.RGBA{0xe5, 0xe5, 0xe5, 0xff},
+	color.RGBA{0x7f, 0x7f, 0x7f, 0xff}, color.RGBA{0xff, 0x00, 0x00, 0xff},
+	color.RGBA{0x00, 0xff, 0x00, 0xff}, color.RGBA{0xff, 0xff, 0x00, 0xff},
+	color.RGBA{0x5c, 0x5c, 0xff, 0xff}, color.RGBA{0xff, 0x00, 0xff, 0xff},
+	color.RGBA{0x00, 0xff, 0xff, 0xff}, color.RGBA{0xff, 0xff, 0xff, 0xff},
+}
+
+// cubeLevels are the values of each channel in the xterm 6x6x6 color cube.
+var cubeLevels = []int{0, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// xterm256 returns the closest color in the xterm 256 colors palette,
+// ignoring the first 16 colors which depend on the terminal theme.
+func xterm256(c color.NRGBA) int {
+	level := func(v uint8) int {
+		best := 0
+		for i, l := range cubeLevels {
+			if abs(int(v)-l) < abs(int(v)-cubeLevels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	r, g, b := level(c.R), level(c.G), level(c.B)
+	cube := 16 + 36*r + 6*g + b
+	cc := color.NRGBA{uint8(cubeLevels[r]), uint8(cubeLevels[g]), uint8(cubeLevels[b]), 0xff}
+
+	// Gray ramp from 8 to 238 in steps of 10.
+	avg := (int(c.R) + int(c.G) + int(c.B)) / 3
+	gi := max(0, min(23, (avg-8+5)/10))
+	gv := uint8(8 + 10*gi)
+	gc := color.NRGBA{gv, gv, gv, 0xff}
+
+	c.A = 0xff
+	if distance(c, gc) < distance(c, cc) {
+		return 232 + gi
+	}
+	return cube
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,224 @@
+package imgcat
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBlocksGolden(t *testing.T) {
+	tc := []struct {
+		golden  string
+		options []Option
+	}{
+		{"icon-half.txt", []Option{Width(Cells(16))}},
+		{"icon-half-256.txt", []Option{Width(Cells(16)), Depth(Colors256)}},
+		{"icon-half-16.txt", []Option{Width(Cells(16)), Depth(Colors16)}},
+		{"icon-quadrants.txt", []Option{Width(Cells(8)), GlyphSet(Quadrants)}},
+		{"icon-braille.txt", []Option{Width(Cells(8)), GlyphSet(Braille)}},
+		{"icon-half-mono.txt", []Option{Width(Cells(16)), Depth(Monochrome)}},
+		{"icon-ascii.txt", []Option{Width(Cells(32)), GlyphSet(ASCII), Depth(Colors256)}},
+		{"icon-ascii-mono.txt", []Option{Width(Cells(32)), GlyphSet(ASCII), Depth(Monochrome)}},
+		{"icon-ascii-edges.txt", []Option{Width(Cells(32)), GlyphSet(ASCIIEdges), Depth(Monochrome)}},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.golden, func(t *testing.T) {
+			f, err := os.Open("testdata/icon.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { check(t, f.Close()) }()
+
+			var buf bytes.Buffer
+			enc, err := NewProtocolEncoder(&buf, Blocks, tt.options...)
+			if err != nil {
+				t.Fatalf("could not create encoder: %v", err)
+			}
+			if err := enc.Encode(f); err != nil {
+				t.Fatalf("could not encode: %v", err)
+			}
+			golden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestHalfBlocks(t *testing.T) {
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+	blue := color.NRGBA{0, 0, 0xff, 0xff}
+	m := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	m.Set(0, 0, red)
+	m.Set(0, 1, blue)
+	m.Set(1, 0, red)
+	m.Set(2, 1, blue)
+
+	tc := []struct {
+		depth ColorDepth
+		out   string
+	}{
+		{TrueColor, "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀\x1b[0m\x1b[38;2;255;0;0m▀\x1b[38;2;0;0;255m▄\x1b[0m\n"},
+		{Colors256, "\x1b[38;5;196m\x1b[48;5;21m▀\x1b[0m\x1b[38;5;196m▀\x1b[38;5;21m▄\x1b[0m\n"},
+		{Colors16, "\x1b[91m\x1b[44m▀\x1b[0m\x1b[91m▀\x1b[34m▄\x1b[0m\n"},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, Blocks, Width(Cells(3)), Height(Cells(1)), PreserveAspectRatio(false), Depth(tt.depth))
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.writeBlocks(m); err != nil {
+			t.Fatalf("could not encode: %v", err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("with depth %d expected %q; got %q", tt.depth, tt.out, got)
+		}
+	}
+}
+
+func TestASCII(t *testing.T) {
+	// A gradient from black to white, with a transparent pixel.
+	m := image.NewNRGBA(image.Rect(0, 0, 11, 1))
+	for x := 0; x < 10; x++ {
+		v := uint8(x * 255 / 9)
+		m.Set(x, 0, color.NRGBA{v, v, v, 0xff})
+	}
+
+	tc := []struct {
+		depth ColorDepth
+		out   string
+	}{
+		{Monochrome, " .:-=+*#%@ \n"},
+		{Colors16, " \x1b[30m.:\x1b[90m-=+*\x1b[37m#%\x1b[97m@\x1b[0m \n"},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, Blocks, Width(Cells(11)), Height(Cells(1)), PreserveAspectRatio(false), GlyphSet(ASCII), Depth(tt.depth))
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.writeBlocks(m); err != nil {
+			t.Fatalf("could not encode: %v", err)
+		}
+		if got := buf.String(); got != tt.out {
+			t.Errorf("with depth %d expected %q; got %q", tt.depth, tt.out, got)
+		}
+	}
+}
+
+func TestASCIIEdges(t *testing.T) {
+	// A light square on a dark background, drawn with square cells.
+	m := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			c := color.NRGBA{0x10, 0x10, 0x10, 0xff}
+			if x >= 2 && x < 6 && y >= 2 && y < 6 {
+				c = color.NRGBA{0xf0, 0xf0, 0xf0, 0xff}
+			}
+			m.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Blocks, Width(Cells(8)), CellSize(1, 1), GlyphSet(ASCIIEdges), Depth(Monochrome))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.writeBlocks(m); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	want := strings.Join([]string{
+		"        ",
+		"        ",
+		"  /--\\  ",
+		"  |@@|  ",
+		"  |@@|  ",
+		"  \\--/  ",
+		"        ",
+		"        ",
+	}, "\n") + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestMonochrome(t *testing.T) {
+	light := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	dark := color.NRGBA{0x20, 0x20, 0x20, 0xff}
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for x, cs := range [][2]color.NRGBA{{light, light}, {light, dark}, {dark, light}, {dark, dark}} {
+		m.Set(x, 0, cs[0])
+		m.Set(x, 1, cs[1])
+	}
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Blocks, Width(Cells(4)), Height(Cells(1)), PreserveAspectRatio(false), Depth(Monochrome))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.writeBlocks(m); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	if got, want := buf.String(), "█▀▄ \n"; got != want {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+}
+
+func TestBlocksBadOptions(t *testing.T) {
+	tc := []Option{Depth(3), GlyphSet("foo")}
+	for _, o := range tc {
+		enc, err := NewProtocolEncoder(ioutil.Discard, Blocks, o)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.writeBlocks(image.NewRGBA(image.Rect(0, 0, 1, 1))); err == nil {
+			t.Errorf("expected error with option %s", o)
+		}
+	}
+}
+
+func TestCellSize(t *testing.T) {
+	defer func(old string) { check(t, os.Setenv("COLUMNS", old)) }(os.Getenv("COLUMNS"))
+	check(t, os.Setenv("COLUMNS", "100"))
+
+	tc := []struct {
+		name       string
+		w, h       int
+		options    []Option
+		cols, rows int
+	}{
+		{"inherent size", 80, 160, nil, 10, 10},
+		{"capped to terminal", 1600, 160, nil, 100, 5},
+		{"width in cells", 80, 160, []Option{Width(Cells(20))}, 20, 20},
+		{"width in percent", 80, 160, []Option{Width(Percent(50))}, 50, 50},
+		{"height in cells", 80, 160, []Option{Height(Cells(5))}, 5, 5},
+		{"fit in box", 80, 160, []Option{Width(Cells(20)), Height(Cells(5))}, 5, 5},
+		{"stretch", 80, 160, []Option{Width(Cells(20)), Height(Cells(5)), PreserveAspectRatio(false)}, 20, 5},
+	}
+	for _, tt := range tc {
+		enc := &Encoder{options: tt.options}
+		cols, rows := enc.cellSize(image.Rect(0, 0, tt.w, tt.h))
+		if cols != tt.cols || rows != tt.rows {
+			t.Errorf("%s: expected %dx%d; got %dx%d", tt.name, tt.cols, tt.rows, cols, rows)
+		}
+	}
+}
+
+func TestXterm256(t *testing.T) {
+	tc := []struct {
+		c color.NRGBA
+		n int
+	}{
+		{color.NRGBA{0, 0, 0, 0xff}, 16},
+		{color.NRGBA{0xff, 0xff, 0xff, 0xff}, 231},
+		{color.NRGBA{0xff, 0, 0, 0xff}, 196},
+		{color.NRGBA{0x80, 0x80, 0x80, 0xff}, 244},
+		{color.NRGBA{0x5f, 0x87, 0xaf, 0xff}, 67},
+	}
+	for _, tt := range tc {
+		if n := xterm256(tt.c); n != tt.n {
+			t.Errorf("expected %v to be color %d; got %d", tt.c, tt.n, n)
+		}
+	}
+}
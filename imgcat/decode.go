@@ -0,0 +1,330 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// A File is a file sent to iTerm2, with the options it was sent with.
+type File struct {
+	Name                string
+	Size                int
+	Width, Height       Length
+	PreserveAspectRatio bool
+	Inline              bool
+	Data                []byte
+}
+
+// A Decoder extracts the files sent with the iTerm2 protocol from a
+// stream, such as a recorded terminal session, including those passed
+// through tmux or GNU screen and those sent in multiple parts.
+type Decoder struct {
+	r    *bufio.Reader
+	text io.Writer
+
+	// file being received in multiple parts.
+	multipart *File
+	parts     bytes.Buffer
+}
+
+// NewDecoder returns a decoder that reads from r and writes everything
+// that is not a file to text, which can be nil to discard it.
+func NewDecoder(r io.Reader, text io.Writer) *Decoder {
+	if text == nil {
+		text = ioutil.Discard
+	}
+	return &Decoder{r: bufio.NewReader(r), text: text}
+}
+
+// Next returns the next file in the stream, copying the text before it.
+// At the end of the stream it returns io.EOF, or io.ErrUnexpectedEOF if
+// the stream ends in the middle of an escape sequence.
+func (d *Decoder) Next() (*File, error) {
+	for {
+		chunk, err := d.r.ReadSlice('\x1b')
+		if err == bufio.ErrBufferFull {
+			if _, err := d.text.Write(chunk); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			if _, werr := d.text.Write(chunk); werr != nil {
+				return nil, werr
+			}
+			return nil, err
+		}
+		if _, err := d.text.Write(chunk[:len(chunk)-1]); err != nil {
+			return nil, err
+		}
+
+		f, err := d.escape()
+		if f != nil || err != nil {
+			return f, err
+		}
+	}
+}
+
+// escape reads an escape sequence, whose ESC has already been read.
+// It returns the file it completes, if any, and otherwise copies it to
+// the text.
+func (d *Decoder) escape() (*File, error) {
+	kind, err := d.r.ReadByte()
+	if err == io.EOF {
+		_, err = d.text.Write([]byte{'\x1b'})
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	var ok bool
+	switch kind {
+	case ']':
+		body, ok = d.osc()
+	case 'P':
+		body, ok = d.dcs()
+	default:
+		_, err := d.text.Write([]byte{'\x1b', kind})
+		return nil, err
+	}
+	raw := append([]byte{'\x1b', kind}, body...)
+	if !ok {
+		if _, err := d.text.Write(raw); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	seq := raw
+	if kind == 'P' && len(body) > 0 && body[0] == '\x1b' {
+		// A sequence passed through GNU screen, in chunks.
+		raw, seq, ok = d.screenChunks(raw)
+		if !ok {
+			if _, err := d.text.Write(raw); err != nil {
+				return nil, err
+			}
+			return nil, io.ErrUnexpectedEOF
+		}
+	}
+	// Sequences are wrapped once per level of nested tmux.
+	for inner, isTmux := tmuxUnwrap(seq); isTmux; inner, isTmux = tmuxUnwrap(seq) {
+		seq = inner
+	}
+	f, handled, err := d.file(seq)
+	if !handled {
+		_, err = d.text.Write(raw)
+	}
+	return f, err
+}
+
+// osc reads the rest of an operating system command, up to and including
+// its BEL or ST terminator. It fails if the stream ends first.
+func (d *Decoder) osc() ([]byte, bool) {
+	var body []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return body, false
+		}
+		body = append(body, b)
+		if b == '\a' || bytes.HasSuffix(body, []byte("\x1b\\")) {
+			return body, true
+		}
+	}
+}
+
+// dcs reads the rest of a device control string, up to and including its
+// ST terminator. Escape characters doubled by tmux are kept doubled.
+// Elsewhere, as GNU screen does, an escape character not followed by a
+// backslash is kept, and the character after it is read again.
+func (d *Decoder) dcs() ([]byte, bool) {
+	var body []byte
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return body, false
+		}
+		body = append(body, b)
+		if b != '\x1b' {
+			continue
+		}
+		next, err := d.r.ReadByte()
+		if err != nil {
+			return body, false
+		}
+		if next == '\\' {
+			return append(body, next), true
+		}
+		if bytes.HasPrefix(body, []byte("tmux;")) {
+			body = append(body, next)
+			continue
+		}
+		if err := d.r.UnreadByte(); err != nil {
+			return body, false
+		}
+	}
+}
+
+// screenChunks reads the chunks following the first one of a sequence
+// passed through GNU screen by Passthrough, until the sequence is
+// complete. It returns all the chunks and the sequence they contain, or
+// fails if the stream ends first. Chunks not followed by the ones that
+// complete the sequence are returned as the sequence.
+func (d *Decoder) screenChunks(first []byte) (raw, seq []byte, ok bool) {
+	raw = first
+	seq = append([]byte(nil), first[len("\x1bP"):len(first)-len("\x1b\\")]...)
+	for !complete(seq) {
+		next, err := d.r.Peek(len("\x1bP"))
+		if err != nil {
+			return raw, seq, false
+		}
+		if string(next) != "\x1bP" {
+			// Not a sequence passed through screen after all.
+			return raw, raw, true
+		}
+		if _, err := d.r.Discard(len(next)); err != nil {
+			return raw, seq, false
+		}
+		body, ok := d.dcs()
+		raw = append(append(raw, "\x1bP"...), body...)
+		if !ok {
+			return raw, seq, false
+		}
+		seq = append(seq, body[:len(body)-len("\x1b\\")]...)
+	}
+	return raw, seq, true
+}
+
+// complete reports whether seq is a whole operating system command or
+// string, terminated by BEL or ST.
+func complete(seq []byte) bool {
+	if bytes.HasPrefix(seq, []byte("\x1b]")) && bytes.HasSuffix(seq, []byte("\a")) {
+		return true
+	}
+	return bytes.HasSuffix(seq, []byte("\x1b\\"))
+}
+
+// tmuxUnwrap returns the sequence wrapped for tmux by Passthrough.
+func tmuxUnwrap(seq []byte) ([]byte, bool) {
+	const prefix, suffix = "\x1bPtmux;", "\x1b\\"
+	if !bytes.HasPrefix(seq, []byte(prefix)) || !bytes.HasSuffix(seq, []byte(suffix)) {
+		return nil, false
+	}
+	inner := seq[len(prefix) : len(seq)-len(suffix)]
+	return bytes.Replace(inner, []byte("\x1b\x1b"), []byte("\x1b"), -1), true
+}
+
+// file handles the iTerm2 file transfer sequences. It reports whether seq
+// is one of them, and returns the file once it has been completely sent.
+func (d *Decoder) file(seq []byte) (*File, bool, error) {
+	s := string(seq)
+	if !strings.HasPrefix(s, "\x1b]1337;") {
+		return nil, false, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s[len("\x1b]1337;"):], "\a"), "\x1b\\")
+
+	switch {
+	case strings.HasPrefix(s, "File="):
+		i := strings.Index(s, ":")
+		if i < 0 {
+			return nil, true, fmt.Errorf("missing file contents")
+		}
+		f, err := parseFile(s[len("File="):i])
+		if err != nil {
+			return nil, true, err
+		}
+		f.Data, err = base64.StdEncoding.DecodeString(s[i+1:])
+		if err != nil {
+			return nil, true, fmt.Errorf("could not decode file %q: %v", f.Name, err)
+		}
+		return f, true, nil
+	case strings.HasPrefix(s, "MultipartFile="):
+		f, err := parseFile(s[len("MultipartFile="):])
+		if err != nil {
+			return nil, true, err
+		}
+		d.multipart = f
+		d.parts.Reset()
+		return nil, true, nil
+	case strings.HasPrefix(s, "FilePart="):
+		if d.multipart == nil {
+			return nil, true, fmt.Errorf("file part with no multipart file")
+		}
+		d.parts.WriteString(s[len("FilePart="):])
+		return nil, true, nil
+	case s == "FileEnd":
+		f := d.multipart
+		if f == nil {
+			return nil, true, fmt.Errorf("file end with no multipart file")
+		}
+		d.multipart = nil
+		data, err := base64.StdEncoding.DecodeString(d.parts.String())
+		if err != nil {
+			return nil, true, fmt.Errorf("could not decode file %q: %v", f.Name, err)
+		}
+		f.Data = data
+		return f, true, nil
+	}
+	return nil, false, nil
+}
+
+// parseFile parses the arguments of a file, as written by Encoder.
+func parseFile(args string) (*File, error) {
+	f := &File{Width: Auto(), Height: Auto(), PreserveAspectRatio: true}
+	if args == "" {
+		return f, nil
+	}
+	for _, arg := range strings.Split(args, ";") {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid file argument %q", arg)
+		}
+		switch k, v := kv[0], kv[1]; k {
+		case "name":
+			name, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode file name %q: %v", v, err)
+			}
+			f.Name = string(name)
+		case "size":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file size %q", v)
+			}
+			f.Size = n
+		case "width":
+			f.Width = Length(v)
+		case "height":
+			f.Height = Length(v)
+		case "preserveAspectRatio":
+			f.PreserveAspectRatio = v != "0"
+		case "inline":
+			f.Inline = v == "1"
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,118 @@
+package imgcat
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecoderRoundTrip(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	img := testPNG(t, 16, 8)
+
+	// The size doesn't depend on the terminal, so the rows are known.
+	options := []Option{Name("cat.png"), Size(len(img)), Width(Cells(10)), Height(Pixels(96)), PreserveAspectRatio(false), Inline(true), CellSize(8, 16)}
+	want := &File{
+		Name:   "cat.png",
+		Size:   len(img),
+		Width:  Cells(10),
+		Height: Pixels(96),
+		Inline: true,
+		Data:   img,
+	}
+
+	// In multiplexers, the 6 rows of the image are reserved before drawing it.
+	reserved := strings.Repeat("\x1bD", 6) + "\x1b[6A\n\x1b[5B"
+	tc := []struct {
+		name  string
+		tmux  string
+		extra []Option
+		text  string
+	}{
+		{"plain", "false", nil, "\n"},
+		{"tmux", "true", nil, reserved},
+		{"multipart", "false", []Option{ChunkSize(64)}, "\n"},
+		{"multipart in tmux", "true", []Option{ChunkSize(64)}, reserved},
+		{"screen", "false", []Option{Multiplexed(Screen)}, reserved},
+		{"multipart in screen", "false", []Option{Multiplexed(Screen), ChunkSize(64)}, reserved},
+	}
+	for _, tt := range tc {
+		check(t, os.Setenv("TMUX_TEST", tt.tmux))
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, ITerm2, append(options, tt.extra...)...)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.Encode(bytes.NewReader(img)); err != nil {
+			t.Fatalf("%s: could not encode: %v", tt.name, err)
+		}
+
+		var text bytes.Buffer
+		dec := NewDecoder(&buf, &text)
+		got, err := dec.Next()
+		if err != nil {
+			t.Fatalf("%s: could not decode: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v; got %+v", tt.name, want, got)
+		}
+		if _, err := dec.Next(); err != io.EOF {
+			t.Errorf("%s: expected end of stream; got %v", tt.name, err)
+		}
+		if text.String() != tt.text {
+			t.Errorf("%s: expected %q as text; got %q", tt.name, tt.text, text.String())
+		}
+	}
+}
+
+func TestDecoderText(t *testing.T) {
+	in := "$ imgcat a.png\r\n" +
+		"\x1b]1337;File=inline=1:YQ==\a\n" +
+		"\x1b]0;title\a\x1b[1mbold\x1b[0m\x1bPq#0;2;0;0;0~\x1b\\" +
+		"\x1bPtmux;\x1b\x1b]1337;File=name=Yi5wbmc=:Yg==\a\x1b\\" +
+		"$ exit"
+	var text bytes.Buffer
+	dec := NewDecoder(strings.NewReader(in), &text)
+
+	var files []*File
+	for {
+		f, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not decode: %v", err)
+		}
+		files = append(files, f)
+	}
+	if len(files) != 2 || string(files[0].Data) != "a" || !files[0].Inline || files[1].Name != "b.png" || string(files[1].Data) != "b" {
+		t.Errorf("unexpected files %+v", files)
+	}
+	want := "$ imgcat a.png\r\n\n\x1b]0;title\a\x1b[1mbold\x1b[0m\x1bPq#0;2;0;0;0~\x1b\\$ exit"
+	if got := text.String(); got != want {
+		t.Errorf("expected text %q; got %q", want, got)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tc := []struct {
+		name string
+		in   string
+		err  error
+	}{
+		{"truncated", "text\x1b]1337;File=:YQ", io.ErrUnexpectedEOF},
+		{"bad payload", "\x1b]1337;File=:!!!\a", nil},
+		{"bad name", "\x1b]1337;File=name=!!!:YQ==\a", nil},
+		{"part without file", "\x1b]1337;FilePart=YQ==\a", nil},
+		{"end without file", "\x1b]1337;FileEnd\a", nil},
+	}
+	for _, tt := range tc {
+		_, err := NewDecoder(strings.NewReader(tt.in), nil).Next()
+		if err == nil || err == io.EOF || (tt.err != nil && err != tt.err) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Multiplexer is a program like tmux sitting between the terminal and
+// the programs running in it.
+type Multiplexer string
+
+const (
+	// NoMultiplexer means the output goes directly to the terminal.
+	NoMultiplexer Multiplexer = ""
+	// Tmux is the tmux terminal multiplexer.
+	Tmux Multiplexer = "tmux"
+	// Screen is the GNU screen terminal multiplexer.
+	Screen Multiplexer = "screen"
+	// NestedTmux is tmux running in another tmux, as when using tmux
+	// over ssh from tmux. It can't be detected.
+	NestedTmux Multiplexer = "tmux-nested"
+)
+
+// Capabilities describes the graphics capabilities of a terminal.
+type Capabilities struct {
+	// Protocols supported by the terminal, the preferred one first.
+	// The Blocks protocol is never listed since it works everywhere.
+	Protocols []Protocol
+	// ColorDepth supported for text.
+	ColorDepth ColorDepth
+	// Size of a cell in pixels, zero when unknown.
+	CellWidth, CellHeight int
+	// SixelColors is the number of sixel color registers, zero when unknown.
+	SixelColors int
+	// Multiplexer the program is running in, if any.
+	Multiplexer Multiplexer
+	// Version is the name and version of the terminal, as reported by XTVERSION.
+	Version string
+}
+
+// Supports reports whether the terminal supports the given protocol.
+func (c Capabilities) Supports(p Protocol) bool {
+	if p == Blocks {
+		return true
+	}
+	for _, q := range c.Protocols {
+		if p == q {
+			return true
+		}
+	}
+	return false
+}
+
+// Best returns the preferred protocol of the terminal. If no graphics
+// protocol is supported it returns Blocks and false.
+func (c Capabilities) Best() (Protocol, bool) {
+	if len(c.Protocols) == 0 {
+		return Blocks, false
+	}
+	return c.Protocols[0], true
+}
+
+// add adds the given protocols, keeping them sorted by preference.
+func (c *Capabilities) add(ps ...Protocol) {
+	for _, p := range ps {
+		if !c.Supports(p) {
+			c.Protocols = append(c.Protocols, p)
+		}
+	}
+	// The iTerm2 protocol sends the file untouched, so it's the cheapest.
+	rank := map[Protocol]int{ITerm2: 0, Kitty: 1, Sixel: 2}
+	sort.SliceStable(c.Protocols, func(i, j int) bool { return rank[c.Protocols[i]] < rank[c.Protocols[j]] })
+}
+
+// Detect returns the capabilities of the current terminal, as described
+// by its environment variables. It never writes to the terminal, use
+// Probe for a more accurate detection.
+func Detect() Capabilities { return detect() }
+
+// Can be swapped for testing.
+var detect = func() Capabilities { return envCapabilities(os.Getenv) }
+
+// DetectProtocol returns the protocol understood by the current terminal,
+// and false if no supported terminal was found.
+func DetectProtocol() (Protocol, bool) { return Detect().Best() }
+
+func envCapabilities(getenv func(string) string) Capabilities {
+	var c Capabilities
+	term, program := getenv("TERM"), getenv("TERM_PROGRAM")
+	switch {
+	case program == "iTerm.app", getenv("LC_TERMINAL") == "iTerm2":
+		c.add(ITerm2)
+	case program == "WezTerm", getenv("WEZTERM_EXECUTABLE") != "":
+		c.add(ITerm2, Kitty, Sixel)
+	case getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty":
+		c.add(Kitty)
+	case term == "foot", strings.HasPrefix(term, "foot-"), term == "mlterm":
+		c.add(Sixel)
+	}
+
+	switch colorterm := getenv("COLORTERM"); {
+	case colorterm == "truecolor", colorterm == "24bit", len(c.Protocols) > 0:
+		c.ColorDepth = TrueColor
+	case strings.Contains(term, "256color"):
+		c.ColorDepth = Colors256
+	default:
+		c.ColorDepth = Colors16
+	}
+
+	switch {
+	case getenv("TMUX") != "", program == "tmux", strings.HasPrefix(term, "tmux"):
+		c.Multiplexer = Tmux
+	case getenv("STY") != "", strings.HasPrefix(term, "screen"):
+		c.Multiplexer = Screen
+	}
+	return c
+}
+
+// Queries sent by Probe. The primary device attributes query goes last:
+// every terminal answers it, so once its response arrives the responses
+// to all the previous queries have arrived too.
+const (
+	queryKitty     = "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\"
+	queryVersion   = "\x1b[>0q"
+	queryGraphics  = "\x1b[?1;1;0S"
+	queryCellSize  = "\x1b[16t"
+	queryAttribute = "\x1b[c"
+)
+
+var (
+	kittyResponse     = regexp.MustCompile("\x1b_Gi=31;OK\x1b\\\\")
+	versionResponse   = regexp.MustCompile("\x1bP>\\|([^\x1b]*)\x1b\\\\")
+	graphicsResponse  = regexp.MustCompile("\x1b\\[\\?1;0;([0-9]+)S")
+	cellSizeResponse  = regexp.MustCompile("\x1b\\[6;([0-9]+);([0-9]+)t")
+	attributeResponse = regexp.MustCompile("\x1b\\[\\?([0-9;]*)c")
+)
+
+// Probe detects the capabilities of the terminal by combining the
+// environment variables with the responses to a series of queries
+// written to the given terminal. The terminal should be in raw mode,
+// as the one returned by OpenTTY.
+// Probe returns whatever was detected when the timeout expires.
+func Probe(tty io.ReadWriter, timeout time.Duration) (Capabilities, error) {
+	c := detect()
+	queries := queryKitty + queryVersion + queryGraphics + queryCellSize + queryAttribute
+	if _, err := io.WriteString(tty, queries); err != nil {
+		return c, err
+	}
+
+	res := readResponses(tty, timeout, func(b []byte) bool { return attributeResponse.Match(b) })
+	if kittyResponse.Match(res) {
+		c.add(Kitty)
+	}
+	if m := versionResponse.FindSubmatch(res); m != nil {
+		c.Version = string(m[1])
+		switch v := strings.ToLower(c.Version); {
+		case strings.Contains(v, "iterm2"):
+			c.add(ITerm2)
+		case strings.Contains(v, "wezterm"):
+			c.add(ITerm2, Kitty, Sixel)
+		case strings.Contains(v, "kitty"):
+			c.add(Kitty)
+		}
+	}
+	if m := graphicsResponse.FindSubmatch(res); m != nil {
+		c.SixelColors, _ = strconv.Atoi(string(m[1]))
+	}
+	if m := cellSizeResponse.FindSubmatch(res); m != nil {
+		c.CellHeight, _ = strconv.Atoi(string(m[1]))
+		c.CellWidth, _ = strconv.Atoi(string(m[2]))
+	}
+	if m := attributeResponse.FindSubmatch(res); m != nil {
+		for _, attr := range strings.Split(string(m[1]), ";") {
+			if attr == "4" {
+				c.add(Sixel)
+			}
+		}
+	}
+	return c, nil
+}
+
+// ProbeTerminal opens the controlling terminal and probes it.
+func ProbeTerminal(timeout time.Duration) (Capabilities, error) {
+	tty, err := OpenTTY()
+	if err != nil {
+		return detect(), err
+	}
+	c, err := Probe(tty, timeout)
+	if cerr := tty.Close(); err == nil {
+		err = cerr
+	}
+	return c, err
+}
+
+// readResponses reads from r until done returns true for what has been
+// read so far, the timeout expires, or r fails.
+//
+// Readers with read deadlines, such as pipes, and those whose reads time
+// out on their own, such as TTY, are read until the timeout only. Other
+// readers are read in the background, where a read may still be pending
+// when the timeout expires, taking the next bytes written to r, so they
+// should not be read again after a query that timed out.
+func readResponses(r io.Reader, timeout time.Duration, done func([]byte) bool) []byte {
+	if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok {
+		if err := d.SetReadDeadline(time.Now().Add(timeout)); err == nil {
+			defer func() { _ = d.SetReadDeadline(time.Time{}) }() // safe to ignore this error.
+			return readUntil(r, time.Time{}, done)
+		}
+	}
+	if _, ok := r.(timedReader); ok {
+		return readUntil(r, time.Now().Add(timeout), done)
+	}
+
+	chunks := make(chan []byte)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(chunks)
+		var read []byte
+		for !done(read) {
+			p := make([]byte, 256)
+			n, err := r.Read(p)
+			if n > 0 {
+				read = append(read, p[:n]...)
+				select {
+				case chunks <- p[:n]:
+				case <-stop:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case p, ok := <-chunks:
+			if !ok {
+				return buf.Bytes()
+			}
+			buf.Write(p)
+		case <-timer.C:
+			return buf.Bytes()
+		}
+	}
+}
+
+// A timedReader is a reader whose reads return no data, rather than
+// block, after a short while, such as a TTY or a type embedding one.
+type timedReader interface {
+	readsTimeOut()
+}
+
+// readUntil reads from r until done returns true for what has been read
+// so far, r fails, or the given deadline, if any, passes. The end of the
+// data only ends reading without deadline, as it means no data yet for
+// readers that time out.
+func readUntil(r io.Reader, deadline time.Time, done func([]byte) bool) []byte {
+	var buf bytes.Buffer
+	p := make([]byte, 256)
+	for !done(buf.Bytes()) {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		n, err := r.Read(p)
+		buf.Write(p[:n])
+		if err == io.EOF && !deadline.IsZero() {
+			continue
+		}
+		if err != nil {
+			break
+		}
+	}
+	return buf.Bytes()
+}
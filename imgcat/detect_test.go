@@ -0,0 +1,215 @@
+package imgcat
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnvCapabilities(t *testing.T) {
+	tc := []struct {
+		name string
+		env  map[string]string
+		want Capabilities
+	}{
+		{"nothing", nil, Capabilities{ColorDepth: Colors16}},
+		{"256 colors", map[string]string{"TERM": "xterm-256color"}, Capabilities{ColorDepth: Colors256}},
+		{"true color", map[string]string{"TERM": "xterm", "COLORTERM": "truecolor"}, Capabilities{ColorDepth: TrueColor}},
+		{"iTerm2", map[string]string{"TERM_PROGRAM": "iTerm.app"}, Capabilities{Protocols: []Protocol{ITerm2}, ColorDepth: TrueColor}},
+		{"iTerm2 over ssh", map[string]string{"LC_TERMINAL": "iTerm2"}, Capabilities{Protocols: []Protocol{ITerm2}, ColorDepth: TrueColor}},
+		{"kitty window", map[string]string{"KITTY_WINDOW_ID": "1"}, Capabilities{Protocols: []Protocol{Kitty}, ColorDepth: TrueColor}},
+		{"kitty term", map[string]string{"TERM": "xterm-kitty"}, Capabilities{Protocols: []Protocol{Kitty}, ColorDepth: TrueColor}},
+		{"WezTerm", map[string]string{"TERM_PROGRAM": "WezTerm"}, Capabilities{Protocols: []Protocol{ITerm2, Kitty, Sixel}, ColorDepth: TrueColor}},
+		{"WezTerm executable", map[string]string{"WEZTERM_EXECUTABLE": "/bin/wezterm"}, Capabilities{Protocols: []Protocol{ITerm2, Kitty, Sixel}, ColorDepth: TrueColor}},
+		{"foot", map[string]string{"TERM": "foot"}, Capabilities{Protocols: []Protocol{Sixel}, ColorDepth: TrueColor}},
+		{"tmux", map[string]string{"TERM": "screen", "TMUX": "/tmp/tmux"}, Capabilities{ColorDepth: Colors16, Multiplexer: Tmux}},
+		{"screen", map[string]string{"TERM": "screen", "STY": "1.pts"}, Capabilities{ColorDepth: Colors16, Multiplexer: Screen}},
+		{"tmux over ssh", map[string]string{"TERM": "tmux-256color"}, Capabilities{ColorDepth: Colors256, Multiplexer: Tmux}},
+	}
+	for _, tt := range tc {
+		got := envCapabilities(func(k string) string { return tt.env[k] })
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %+v; got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestDetectProtocol(t *testing.T) {
+	defer func(old func() Capabilities) { detect = old }(detect)
+
+	detect = func() Capabilities { return Capabilities{Protocols: []Protocol{Kitty, Sixel}} }
+	if p, ok := DetectProtocol(); p != Kitty || !ok {
+		t.Errorf("expected kitty; got %v, %v", p, ok)
+	}
+	if !IsSupported() {
+		t.Errorf("expected kitty to be supported")
+	}
+
+	detect = func() Capabilities { return Capabilities{} }
+	if p, ok := DetectProtocol(); p != Blocks || ok {
+		t.Errorf("expected blocks; got %v, %v", p, ok)
+	}
+	if IsSupported() {
+		t.Errorf("expected no support")
+	}
+}
+
+// fakeTTY answers the queries written to it with the configured responses.
+type fakeTTY struct {
+	responses map[string]string
+	mu        sync.Mutex
+	out       bytes.Buffer
+	ready     chan struct{}
+	closed    chan struct{}
+}
+
+func newFakeTTY(responses map[string]string) *fakeTTY {
+	return &fakeTTY{responses: responses, ready: make(chan struct{}), closed: make(chan struct{})}
+}
+
+func (f *fakeTTY) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, q := range []string{queryKitty, queryVersion, queryGraphics, queryWindowCells, queryWindowPixels, queryCellSize, queryBackground, queryAttribute} {
+		if strings.Contains(string(p), q) {
+			f.out.WriteString(f.responses[q])
+		}
+	}
+	close(f.ready)
+	return len(p), nil
+}
+
+// Read returns the responses a few bytes at a time, then blocks until
+// closed like a terminal that doesn't answer.
+func (f *fakeTTY) Read(p []byte) (int, error) {
+	<-f.ready
+	f.mu.Lock()
+	n := f.out.Len()
+	f.mu.Unlock()
+	if n == 0 {
+		<-f.closed
+		return 0, io.EOF
+	}
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.out.Read(p)
+}
+
+func (f *fakeTTY) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func TestProbe(t *testing.T) {
+	defer func(old func() Capabilities) { detect = old }(detect)
+	detect = func() Capabilities { return Capabilities{ColorDepth: Colors256} }
+
+	tc := []struct {
+		name      string
+		responses map[string]string
+		want      Capabilities
+	}{
+		{"no graphics", map[string]string{
+			queryAttribute: "\x1b[?62;22c",
+		}, Capabilities{ColorDepth: Colors256}},
+		{"sixel", map[string]string{
+			queryVersion:   "\x1bP>|XTerm(379)\x1b\\",
+			queryGraphics:  "\x1b[?1;0;1024S",
+			queryCellSize:  "\x1b[6;20;10t",
+			queryAttribute: "\x1b[?63;1;2;4;6;9;15;22c",
+		}, Capabilities{Protocols: []Protocol{Sixel}, ColorDepth: Colors256, CellWidth: 10, CellHeight: 20, SixelColors: 1024, Version: "XTerm(379)"}},
+		{"kitty", map[string]string{
+			queryKitty:     "\x1b_Gi=31;OK\x1b\\",
+			queryVersion:   "\x1bP>|kitty(0.31.0)\x1b\\",
+			queryCellSize:  "\x1b[6;17;8t",
+			queryAttribute: "\x1b[?62;c",
+		}, Capabilities{Protocols: []Protocol{Kitty}, ColorDepth: Colors256, CellWidth: 8, CellHeight: 17, Version: "kitty(0.31.0)"}},
+		{"iTerm2", map[string]string{
+			queryVersion:   "\x1bP>|iTerm2 3.5.0\x1b\\",
+			queryAttribute: "\x1b[?62;4c",
+		}, Capabilities{Protocols: []Protocol{ITerm2, Sixel}, ColorDepth: Colors256, Version: "iTerm2 3.5.0"}},
+	}
+	for _, tt := range tc {
+		tty := newFakeTTY(tt.responses)
+		got, err := Probe(tty, time.Second)
+		check(t, tty.Close())
+		if err != nil {
+			t.Fatalf("%s: could not probe: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %+v; got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	defer func(old func() Capabilities) { detect = old }(detect)
+	detect = func() Capabilities { return Capabilities{} }
+
+	tty := newFakeTTY(map[string]string{queryKitty: "\x1b_Gi=31;OK\x1b\\"})
+	defer func() { check(t, tty.Close()) }()
+	start := time.Now()
+	got, err := Probe(tty, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not probe: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("probe took %v", d)
+	}
+	if !got.Supports(Kitty) {
+		t.Errorf("expected kitty support from the partial response; got %+v", got)
+	}
+}
+
+// timedTTY is a terminal whose reads return no data when there's none,
+// like a TTY.
+type timedTTY struct {
+	*TTY
+	in bytes.Buffer
+}
+
+func (t *timedTTY) Read(p []byte) (int, error) {
+	n, _ := t.in.Read(p)
+	return n, nil
+}
+
+// TestReadResponsesTimeout checks that the bytes written after a query
+// timed out are left to be read.
+func TestReadResponsesTimeout(t *testing.T) {
+	done := func(b []byte) bool { return attributeResponse.Match(b) }
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("could not create pipe: %v", err)
+	}
+	defer func() { check(t, r.Close()) }()
+	defer func() { check(t, w.Close()) }()
+	if res := readResponses(r, 20*time.Millisecond, done); len(res) > 0 {
+		t.Errorf("unexpected response %q", res)
+	}
+	_, err = w.Write([]byte("q"))
+	check(t, err)
+	p := make([]byte, 8)
+	n, err := r.Read(p)
+	check(t, err)
+	if got := string(p[:n]); got != "q" {
+		t.Errorf("expected to read %q after the query; got %q", "q", got)
+	}
+
+	tty := &timedTTY{TTY: &TTY{}}
+	if res := readResponses(tty, 20*time.Millisecond, done); len(res) > 0 {
+		t.Errorf("unexpected response %q", res)
+	}
+	tty.in.WriteString("q")
+	if n, _ := tty.Read(p); string(p[:n]) != "q" {
+		t.Errorf("expected to read %q after the query; got %q", "q", p[:n])
+	}
+}
@@ -14,8 +14,11 @@
 package imgcat_test
 
 import (
+	"image"
+	"image/color"
 	"log"
 	"os"
+	"time"
 
 	"github.com/campoy/tools/imgcat"
 )
@@ -37,3 +40,80 @@ func ExampleNewEncoder() {
 		log.Fatal(err)
 	}
 }
+
+func ExampleEncoder_EncodeImage() {
+	enc, err := imgcat.NewEncoder(os.Stdout, imgcat.Width(imgcat.Cells(20)), imgcat.Inline(true))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Draw a gradient in memory.
+	m := image.NewRGBA(image.Rect(0, 0, 256, 64))
+	for x := 0; x < 256; x++ {
+		for y := 0; y < 64; y++ {
+			m.Set(x, y, color.RGBA{uint8(x), uint8(y * 4), 0x80, 0xff})
+		}
+	}
+
+	// Display the image in the terminal.
+	if err := enc.EncodeImage(m); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleNewProtocolEncoder_ascii() {
+	// Draw images as plain text, such as in logs and files.
+	enc, err := imgcat.NewProtocolEncoder(os.Stdout, imgcat.Blocks,
+		imgcat.Width(imgcat.Cells(16)), imgcat.GlyphSet(imgcat.ASCII), imgcat.Depth(imgcat.Monochrome))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A light disc on a dark background.
+	m := image.NewGray(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			if d := (x-32)*(x-32) + (y-32)*(y-32); d < 28*28 {
+				m.Set(x, y, color.Gray{uint8(255 - d*200/(28*28))})
+			}
+		}
+	}
+
+	if err := enc.EncodeImage(m); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleEncoder_Place() {
+	enc, err := imgcat.NewEncoder(os.Stdout, imgcat.Width(imgcat.Cells(40)))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// chart draws a bar that grows with n.
+	chart := func(n int) image.Image {
+		m := image.NewRGBA(image.Rect(0, 0, 200, 40))
+		for x := 0; x < n*20; x++ {
+			for y := 0; y < 40; y++ {
+				m.Set(x, y, color.RGBA{0x40, 0x80, 0xff, 0xff})
+			}
+		}
+		return m
+	}
+
+	// Draw the chart at the top left of the screen, and update it every
+	// second.
+	p, err := enc.Place(chart(0), 1, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for n := 1; n <= 10; n++ {
+		time.Sleep(time.Second)
+		if err := p.Replace(chart(n)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := p.Delete(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+// KeepMetadata set to true sends files to iTerm2 and kitty with all their
+// metadata. By default, the metadata that doesn't change how the image
+// looks, such as thumbnails, comments, or XMP, is removed to send less
+// data. Color profiles are always kept, and so is the orientation of the
+// EXIF data. Files are never modified when their Size is set, nor when
+// iTerm2 downloads them rather than displaying them inline.
+func KeepMetadata(b bool) Option {
+	return Option{"keepMetadata", fmt.Sprint(boolToInt(b)), func(o *Options) { o.KeepMetadata = b }}
+}
+
+// DecodeImage decodes an image like image.Decode, and then rotates or
+// flips it as the EXIF orientation of JPEG files says, as cameras store
+// the pixels in the orientation of their sensor.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	m, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if format == "jpeg" {
+		m = orient(m, jpegOrientation(data))
+	}
+	return m, format, nil
+}
+
+// An orientation is the value of the EXIF Orientation tag, from 1 to 8.
+// It says how the stored image should be transformed to be displayed.
+type orientation int
+
+const (
+	topLeft     orientation = 1 + iota // none
+	topRight                           // flip horizontally
+	bottomRight                        // rotate 180°
+	bottomLeft                         // flip vertically
+	leftTop                            // transpose
+	rightTop                           // rotate 90° clockwise
+	rightBottom                        // transverse
+	leftBottom                         // rotate 90° counter clockwise
+)
+
+// swapsAxes reports whether the displayed image is the stored one turned
+// on its side.
+func (o orientation) swapsAxes() bool { return o >= leftTop }
+
+// orient returns m transformed as the orientation says.
+func orient(m image.Image, o orientation) image.Image {
+	if o <= topLeft || o > leftBottom {
+		return m
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o.swapsAxes() {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case topRight:
+				sx, sy = w-1-x, y
+			case bottomRight:
+				sx, sy = w-1-x, h-1-y
+			case bottomLeft:
+				sx, sy = x, h-1-y
+			case leftTop:
+				sx, sy = y, x
+			case rightTop:
+				sx, sy = y, h-1-x
+			case rightBottom:
+				sx, sy = w-1-y, h-1-x
+			case leftBottom:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, m.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+var (
+	jpegMagic  = []byte("\xff\xd8")
+	exifHeader = []byte("Exif\x00\x00")
+	iccHeader  = []byte("ICC_PROFILE\x00")
+)
+
+// JPEG markers.
+const (
+	markerSOS   = 0xda
+	markerAPP0  = 0xe0
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP14 = 0xee
+	markerAPP15 = 0xef
+	markerCOM   = 0xfe
+)
+
+// jpegOrientation returns the EXIF orientation of a JPEG file, which is
+// topLeft if it has none.
+func jpegOrientation(data []byte) orientation {
+	if !bytes.HasPrefix(data, jpegMagic) {
+		return topLeft
+	}
+	r := bufio.NewReader(bytes.NewReader(data[len(jpegMagic):]))
+	for {
+		seg, err := nextJPEGSegment(r)
+		if err != nil {
+			return topLeft
+		}
+		if o, ok := exifOrientation(seg); ok {
+			return o
+		}
+	}
+}
+
+// nextJPEGSegment reads the next segment of a JPEG file, marker included.
+// It returns io.EOF when the next one is the image data, or anything it
+// doesn't know how to parse.
+func nextJPEGSegment(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(4)
+	if err != nil || head[0] != 0xff || head[1] < 0xc0 || head[1] == markerSOS || (head[1] >= 0xd0 && head[1] <= 0xd9) {
+		return nil, io.EOF
+	}
+	n := int(binary.BigEndian.Uint16(head[2:]))
+	if n < 2 {
+		return nil, io.EOF
+	}
+	seg := make([]byte, 2+n)
+	if _, err := io.ReadFull(r, seg); err != nil {
+		return nil, fmt.Errorf("could not read jpeg segment: %v", err)
+	}
+	return seg, nil
+}
+
+// exifOrientation returns the orientation in an APP1 segment with EXIF
+// data, and whether the segment is one.
+func exifOrientation(seg []byte) (orientation, bool) {
+	if seg[1] != markerAPP1 || !bytes.HasPrefix(seg[4:], exifHeader) {
+		return 0, false
+	}
+	return tiffOrientation(seg[4+len(exifHeader):]), true
+}
+
+// tiffOrientation finds the Orientation tag in the first directory of the
+// TIFF structure that holds EXIF data.
+func tiffOrientation(b []byte) orientation {
+	if len(b) < 8 {
+		return topLeft
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return topLeft
+	}
+	ifd := int(order.Uint32(b[4:]))
+	if ifd < 8 || ifd+2 > len(b) {
+		return topLeft
+	}
+	n := int(order.Uint16(b[ifd:]))
+	for i := 0; i < n; i++ {
+		e := b[ifd+2+12*i:]
+		if len(e) < 12 {
+			break
+		}
+		if order.Uint16(e) == 0x0112 {
+			if o := orientation(order.Uint16(e[8:])); o >= topLeft && o <= leftBottom {
+				return o
+			}
+			break
+		}
+	}
+	return topLeft
+}
+
+// exifSegment returns an APP1 segment with EXIF data holding only the
+// given orientation.
+func exifSegment(o orientation) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8, // header, with the directory at 8.
+		0, 1, // a single entry:
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(o), 0, 0, // orientation, a short.
+		0, 0, 0, 0, // no more directories.
+	}
+	n := 2 + len(exifHeader) + len(tiff)
+	seg := []byte{0xff, markerAPP1, byte(n >> 8), byte(n)}
+	seg = append(seg, exifHeader...)
+	return append(seg, tiff...)
+}
+
+// keepJPEGSegment returns what should be sent of a segment: the segment
+// itself, a smaller replacement, or nothing.
+func keepJPEGSegment(seg []byte) []byte {
+	if o, ok := exifOrientation(seg); ok {
+		if o == topLeft {
+			return nil
+		}
+		return exifSegment(o)
+	}
+	data := seg[4:]
+	switch m := seg[1]; {
+	case m == markerAPP0:
+		// JFIF tells the decoder the color space, JFXX has a thumbnail.
+		if bytes.HasPrefix(data, []byte("JFIF\x00")) {
+			return seg
+		}
+		return nil
+	case m == markerAPP2:
+		// Keep the color profile, but not the previews of MPF.
+		if bytes.HasPrefix(data, iccHeader) {
+			return seg
+		}
+		return nil
+	case m == markerAPP14:
+		// Adobe's tells the decoder the color transform.
+		return seg
+	case m == markerCOM || (m >= markerAPP1 && m <= markerAPP15):
+		return nil
+	}
+	return seg
+}
+
+// PNG chunks that are only text.
+var pngTextChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true}
+
+// Largest PNG chunk kept in memory while stripping metadata. Files with
+// larger chunks before the image data are sent from that chunk on.
+const maxPNGChunk = 1 << 24
+
+// nextPNGChunk reads the next chunk of a PNG file, or nothing if it's only
+// text. It returns io.EOF when the next one is the image data.
+func nextPNGChunk(r *bufio.Reader) ([]byte, error) {
+	head, err := r.Peek(8)
+	if err != nil {
+		return nil, io.EOF
+	}
+	n, typ := int(binary.BigEndian.Uint32(head)), string(head[4:])
+	if typ == "IDAT" || n > maxPNGChunk {
+		return nil, io.EOF
+	}
+	chunk := make([]byte, 12+n)
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		return nil, fmt.Errorf("could not read png chunk: %v", err)
+	}
+	if pngTextChunks[typ] {
+		return nil, nil
+	}
+	return chunk, nil
+}
+
+// A stripper copies a file leaving out some of its segments, then the
+// rest of the file as is.
+type stripper struct {
+	r   *bufio.Reader
+	buf []byte
+	// next returns the next segment to copy, if any, or io.EOF once the
+	// rest of the file should be copied as is.
+	next    func(*bufio.Reader) ([]byte, error)
+	started bool
+	copying bool
+}
+
+// stripMetadata returns a reader with the contents of r, without the
+// metadata that doesn't change how the image looks if r is a JPEG or
+// PNG file. Nothing is read from r until the first call to Read.
+func stripMetadata(r io.Reader) io.Reader {
+	return &stripper{r: bufio.NewReader(r)}
+}
+
+// start finds out the format of the file from its first bytes.
+func (s *stripper) start() {
+	s.started = true
+	// Peek only as much as needed, not to wait for more data than written.
+	head, _ := s.r.Peek(len(jpegMagic))
+	switch {
+	case bytes.Equal(head, jpegMagic):
+		s.next = func(r *bufio.Reader) ([]byte, error) {
+			seg, err := nextJPEGSegment(r)
+			if err != nil {
+				return nil, err
+			}
+			return keepJPEGSegment(seg), nil
+		}
+	case len(head) > 0 && bytes.Equal(head, pngMagic[:len(head)]):
+		if head, _ = s.r.Peek(len(pngMagic)); !bytes.Equal(head, pngMagic) {
+			s.copying = true
+			return
+		}
+		s.next = nextPNGChunk
+	default:
+		s.copying = true
+		return
+	}
+	s.buf = append([]byte(nil), head...)
+	_, _ = s.r.Discard(len(head)) // the bytes were peeked already.
+}
+
+func (s *stripper) Read(p []byte) (int, error) {
+	if !s.started {
+		s.start()
+	}
+	for len(s.buf) == 0 && !s.copying {
+		seg, err := s.next(s.r)
+		if err == io.EOF {
+			s.copying = true
+		} else if err != nil {
+			return 0, err
+		}
+		s.buf = seg
+	}
+	if len(s.buf) > 0 {
+		n := copy(p, s.buf)
+		s.buf = s.buf[n:]
+		return n, nil
+	}
+	return s.r.Read(p)
+}
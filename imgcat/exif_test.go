@@ -0,0 +1,250 @@
+package imgcat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// segment returns a JPEG segment with the given marker and data.
+func segment(marker byte, data string) []byte {
+	n := 2 + len(data)
+	return append([]byte{0xff, marker, byte(n >> 8), byte(n)}, data...)
+}
+
+// withSegments returns the JPEG file with the segments added after its
+// start of image marker.
+func withSegments(file []byte, segs ...[]byte) []byte {
+	out := append([]byte(nil), file[:2]...)
+	for _, seg := range segs {
+		out = append(out, seg...)
+	}
+	return append(out, file[2:]...)
+}
+
+// testJPEG returns a JPEG file of 16x8 pixels, red on the left half and
+// blue on the right one.
+func testJPEG(t *testing.T) []byte {
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			c := color.NRGBA{0xff, 0, 0, 0xff}
+			if x >= 8 {
+				c = color.NRGBA{0, 0, 0xff, 0xff}
+			}
+			m.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	check(t, jpeg.Encode(&buf, m, &jpeg.Options{Quality: 100}))
+	return buf.Bytes()
+}
+
+func TestOrient(t *testing.T) {
+	// 1 2 3
+	// 4 5 6
+	m := image.NewGray(image.Rect(10, 10, 13, 12))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i + 1)
+	}
+	tc := []struct {
+		o    orientation
+		want []string
+	}{
+		{topLeft, []string{"123", "456"}},
+		{topRight, []string{"321", "654"}},
+		{bottomRight, []string{"654", "321"}},
+		{bottomLeft, []string{"456", "123"}},
+		{leftTop, []string{"14", "25", "36"}},
+		{rightTop, []string{"41", "52", "63"}},
+		{rightBottom, []string{"63", "52", "41"}},
+		{leftBottom, []string{"36", "25", "14"}},
+	}
+	for _, tt := range tc {
+		got := orient(m, tt.o)
+		b := got.Bounds()
+		var rows []string
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := ""
+			for x := b.Min.X; x < b.Max.X; x++ {
+				row += string('0' + rune(color.GrayModel.Convert(got.At(x, y)).(color.Gray).Y))
+			}
+			rows = append(rows, row)
+		}
+		if strings.Join(rows, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("orientation %d: got %v; want %v", tt.o, rows, tt.want)
+		}
+	}
+}
+
+func TestTIFFOrientation(t *testing.T) {
+	le := []byte("II*\x00\x08\x00\x00\x00\x02\x00")
+	le = append(le, 0x0f, 0x01, 2, 0, 1, 0, 0, 0, 'x', 0, 0, 0) // make, ignored.
+	le = append(le, 0x12, 0x01, 3, 0, 1, 0, 0, 0, 8, 0, 0, 0)
+	tc := []struct {
+		name string
+		tiff []byte
+		want orientation
+	}{
+		{"big endian", exifSegment(rightTop)[10:], rightTop},
+		{"little endian", le, leftBottom},
+		{"no tag", []byte("MM\x00*\x00\x00\x00\x08\x00\x00"), topLeft},
+		{"out of range", exifSegment(9)[10:], topLeft},
+		{"bad directory", []byte("MM\x00*\x00\x00\xff\xff\x00\x00"), topLeft},
+		{"bad byte order", []byte("XX\x00*\x00\x00\x00\x08\x00\x00"), topLeft},
+		{"short", []byte("MM"), topLeft},
+	}
+	for _, tt := range tc {
+		if got := tiffOrientation(tt.tiff); got != tt.want {
+			t.Errorf("%s: got orientation %d; want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	file := testJPEG(t)
+	tc := []struct {
+		name string
+		file []byte
+		// size of the decoded image, and color of its top left corner.
+		w, h int
+		red  bool
+	}{
+		{"no exif", file, 16, 8, true},
+		{"no orientation", withSegments(file, exifSegment(topLeft)), 16, 8, true},
+		{"flipped", withSegments(file, exifSegment(topRight)), 16, 8, false},
+		{"rotated clockwise", withSegments(file, exifSegment(rightTop)), 8, 16, true},
+		{"rotated counter clockwise", withSegments(file, exifSegment(leftBottom)), 8, 16, false},
+	}
+	for _, tt := range tc {
+		m, format, err := DecodeImage(bytes.NewReader(tt.file))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if format != "jpeg" {
+			t.Errorf("%s: format is %q; want jpeg", tt.name, format)
+		}
+		if b := m.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("%s: size is %dx%d; want %dx%d", tt.name, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		r, _, _, _ := m.At(m.Bounds().Min.X, m.Bounds().Min.Y).RGBA()
+		if red := r > 0x8000; red != tt.red {
+			t.Errorf("%s: top left corner red is %v; want %v", tt.name, red, tt.red)
+		}
+	}
+
+	if _, _, err := DecodeImage(strings.NewReader("not an image")); err == nil {
+		t.Errorf("expected error decoding text")
+	}
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	file := testJPEG(t)
+	exif := exifSegment(rightTop)
+	// Camera EXIF has many more tags, and a thumbnail.
+	binary.BigEndian.PutUint16(exif[2:], uint16(len(exif)-2+1000))
+	exif = append(exif, make([]byte, 1000)...)
+	icc := segment(markerAPP2, "ICC_PROFILE\x00\x01\x01profile")
+	in := withSegments(file,
+		exif,
+		segment(markerAPP1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"),
+		icc,
+		segment(markerAPP2, "MPF\x00preview"),
+		segment(markerCOM, "a comment"),
+	)
+
+	out, err := ioutil.ReadAll(stripMetadata(bytes.NewReader(in)))
+	check(t, err)
+	if want := withSegments(file, exifSegment(rightTop), icc); !bytes.Equal(out, want) {
+		t.Errorf("stripped file has %d bytes; want %d", len(out), len(want))
+	}
+	if jpegOrientation(out) != rightTop {
+		t.Errorf("stripped file lost its orientation")
+	}
+
+	// Files with no orientation lose their EXIF data.
+	out, err = ioutil.ReadAll(stripMetadata(bytes.NewReader(withSegments(file, exifSegment(topLeft)))))
+	check(t, err)
+	if !bytes.Equal(out, file) {
+		t.Errorf("EXIF data with no orientation was not removed")
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	check(t, png.Encode(&buf, testImage()))
+	file := buf.Bytes()
+
+	// Add a text chunk after the header, which is 8+25 bytes long.
+	text := []byte("\x00\x00\x00\x07tEXtComment\x00hi\x00\x00\x00\x00")
+	binary.BigEndian.PutUint32(text, uint32(len(text)-12))
+	in := append(append(append([]byte(nil), file[:33]...), text...), file[33:]...)
+
+	out, err := ioutil.ReadAll(stripMetadata(bytes.NewReader(in)))
+	check(t, err)
+	if !bytes.Equal(out, file) {
+		t.Errorf("text chunk was not removed")
+	}
+}
+
+func TestStripOther(t *testing.T) {
+	for _, in := range []string{"", "test", "\xff\xd8", "\xff\xd8\xff\xe1\x00"} {
+		out, err := ioutil.ReadAll(stripMetadata(strings.NewReader(in)))
+		check(t, err)
+		if string(out) != in {
+			t.Errorf("stripMetadata(%q) returned %q", in, out)
+		}
+	}
+	_, err := ioutil.ReadAll(stripMetadata(strings.NewReader("\xff\xd8\xff\xfe\x00\x10short")))
+	if err == nil {
+		t.Errorf("expected error reading a truncated segment")
+	}
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	file := testJPEG(t)
+	in := withSegments(file, segment(markerCOM, "a comment"))
+	tc := []struct {
+		name    string
+		options []Option
+		want    []byte
+	}{
+		{"default", []Option{Inline(true)}, file},
+		{"keep metadata", []Option{Inline(true), KeepMetadata(true)}, in},
+		{"size", []Option{Inline(true), Size(len(in))}, in},
+		{"download", nil, in},
+		{"explicit download", []Option{Inline(false)}, in},
+	}
+	for _, tt := range tc {
+		for _, p := range []Protocol{ITerm2, Kitty} {
+			var buf bytes.Buffer
+			enc, err := NewProtocolEncoder(&buf, p, tt.options...)
+			check(t, err)
+			check(t, enc.Encode(bytes.NewReader(in)))
+			if p == Kitty {
+				// kitty receives the image as PNG.
+				if buf.Len() == 0 {
+					t.Errorf("%s: nothing sent to kitty", tt.name)
+				}
+				continue
+			}
+			f, err := NewDecoder(&buf, nil).Next()
+			check(t, err)
+			if !bytes.Equal(f.Data, tt.want) {
+				t.Errorf("%s: sent %d bytes; want %d", tt.name, len(f.Data), len(tt.want))
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPaths replaces the directories in the given paths with the files
+// they contain, recursively, keeping only those with one of the given
+// extensions, such as ".png", ignoring case. Other paths, such as "-" for
+// the standard input or files that don't exist, are kept as they are.
+// The errors reading the directories are returned with the files found.
+func ExpandPaths(args, exts []string) (paths []string, errs []error) {
+	keep := map[string]bool{}
+	for _, ext := range exts {
+		keep[strings.ToLower(strings.TrimSpace(ext))] = true
+	}
+	for _, arg := range args {
+		if fi, err := os.Stat(arg); arg == "-" || err != nil || !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		err := filepath.Walk(arg, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not read %s: %v", path, err))
+				return nil
+			}
+			if !fi.IsDir() && keep[strings.ToLower(filepath.Ext(path))] {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not read %s: %v", arg, err))
+		}
+	}
+	return paths, errs
+}
@@ -0,0 +1,32 @@
+package imgcat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgcat")
+	if err != nil {
+		t.Fatalf("could not create directory: %v", err)
+	}
+	defer func() { check(t, os.RemoveAll(dir)) }()
+	for _, name := range []string{"a.png", "b.txt", "sub/c.JPG"} {
+		path := filepath.Join(dir, name)
+		check(t, os.MkdirAll(filepath.Dir(path), 0755))
+		check(t, ioutil.WriteFile(path, nil, 0644))
+	}
+
+	args := []string{"-", dir, "missing.png"}
+	paths, errs := ExpandPaths(args, []string{".png", " .jpg"})
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	want := []string{"-", filepath.Join(dir, "a.png"), filepath.Join(dir, "sub/c.JPG"), "missing.png"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected paths %v; got %v", want, paths)
+	}
+}
@@ -0,0 +1,10 @@
+package imgcat_test
+
+import (
+	"github.com/campoy/tools/imgcat"
+	"github.com/campoy/tools/imgcat/imgtest"
+)
+
+// The golden files of the package are checked with imgtest, which shares
+// its -update flag with the other packages.
+func init() { imgcat.GoldenFile = imgtest.Golden }
@@ -0,0 +1,100 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+)
+
+// EncodeImage encodes the given image into the output, choosing the most
+// efficient encoding for the protocol of the encoder: PNG for iTerm2,
+// compressed raw pixels for kitty, and quantized colors for sixel.
+// Unless given as an option, the size sent to iTerm2 is filled
+// automatically. The given options override the ones of the encoder for
+// this image only.
+func (enc *Encoder) EncodeImage(m image.Image, options ...Option) error {
+	enc, err := enc.with(options)
+	if err != nil {
+		return err
+	}
+	if enc.multiplexed() {
+		return enc.reserveRows(m.Bounds(), func(enc *Encoder) error { return enc.encodeImage(m) })
+	}
+	return enc.encodeImage(m)
+}
+
+func (enc *Encoder) encodeImage(m image.Image) error {
+	switch enc.protocol {
+	case Kitty:
+		return enc.encodeKittyImage(m)
+	case Sixel:
+		return enc.writeSixel(m)
+	case Blocks:
+		return enc.writeBlocks(m)
+	default:
+		_, data, err := enc.fit(m, encodePNG)
+		if err != nil {
+			return err
+		}
+		if enc.opts().Size == 0 {
+			enc, _ = enc.with([]Option{Size(len(data))}) // a size is always valid.
+		}
+		return enc.encodeITerm2(bytes.NewReader(data))
+	}
+}
+
+func encodePNG(m image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, m); err != nil {
+		return nil, fmt.Errorf("could not encode image as png: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeKittyImage sends the pixels of the image as zlib compressed RGBA.
+func (enc *Encoder) encodeKittyImage(m image.Image) error {
+	m, data, err := enc.fit(m, encodeRGBA)
+	if err != nil {
+		return err
+	}
+	b := m.Bounds()
+	format := []string{"f=32", fmt.Sprintf("s=%d", b.Dx()), fmt.Sprintf("v=%d", b.Dy()), "o=z"}
+	cfg := image.Config{Width: b.Dx(), Height: b.Dy()}
+	return enc.sendKitty(format, cfg, bytes.NewReader(data))
+}
+
+// encodeRGBA returns the zlib compressed non premultiplied pixels of m.
+func encodeRGBA(m image.Image) ([]byte, error) {
+	b := m.Bounds()
+	nrgba, ok := m.(*image.NRGBA)
+	if !ok || nrgba.Stride != 4*b.Dx() {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), m, b.Min, draw.Src)
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zlib.NewWriter(buf)
+	if _, err := zw.Write(nrgba.Pix[:4*b.Dx()*b.Dy()]); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+package imgcat
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func testImage() *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 3; y++ {
+			m.Set(x, y, color.NRGBA{uint8(x * 60), uint8(y * 100), 0x80, 0xff})
+		}
+	}
+	return m
+}
+
+func TestEncodeImageITerm2(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	m := testImage()
+	var want bytes.Buffer
+	if err := png.Encode(&want, m); err != nil {
+		t.Fatalf("could not encode png: %v", err)
+	}
+	data := base64.StdEncoding.EncodeToString(want.Bytes())
+
+	tc := []struct {
+		name    string
+		options []Option
+		header  string
+	}{
+		{"size is filled", []Option{Inline(true)}, fmt.Sprintf("inline=1;size=%d", want.Len())},
+		{"size is kept", []Option{Size(42)}, "size=42"},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, ITerm2, tt.options...)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.EncodeImage(m); err != nil {
+			t.Fatalf("could not encode: %v", err)
+		}
+		out := "\x1b]1337;File=" + tt.header + ":" + data + "\a\n"
+		if got := buf.String(); got != out {
+			t.Errorf("%s: expected %q; got %q", tt.name, out, got)
+		}
+	}
+}
+
+func TestEncodeImageKitty(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	// A sub image exercises the conversion of the pixels.
+	m := testImage().SubImage(image.Rect(1, 1, 3, 3))
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty, Width(Cells(2)))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.EncodeImage(m); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+
+	re := regexp.MustCompile("^\x1b_Ga=T,f=32,s=2,v=2,o=z,q=2,i=1,p=1,c=2,m=0;([^\x1b]*)\x1b\\\\\n$")
+	match := re.FindStringSubmatch(buf.String())
+	if match == nil {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	z, err := base64.StdEncoding.DecodeString(match[1])
+	if err != nil {
+		t.Fatalf("could not decode payload: %v", err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(z))
+	if err != nil {
+		t.Fatalf("could not decompress payload: %v", err)
+	}
+	pix, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("could not decompress payload: %v", err)
+	}
+	want := []byte{
+		60, 100, 0x80, 0xff, 120, 100, 0x80, 0xff,
+		60, 200, 0x80, 0xff, 120, 200, 0x80, 0xff,
+	}
+	if !bytes.Equal(pix, want) {
+		t.Errorf("expected pixels %v; got %v", want, pix)
+	}
+}
+
+func TestEncodeImageMatchesEncode(t *testing.T) {
+	m := testImage()
+	var in bytes.Buffer
+	if err := png.Encode(&in, m); err != nil {
+		t.Fatalf("could not encode png: %v", err)
+	}
+
+	for _, p := range []Protocol{Sixel, Blocks} {
+		var fromImage, fromFile bytes.Buffer
+		enc, err := NewProtocolEncoder(&fromImage, p)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.EncodeImage(m); err != nil {
+			t.Fatalf("could not encode image: %v", err)
+		}
+		enc, err = NewProtocolEncoder(&fromFile, p)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.Encode(bytes.NewReader(in.Bytes())); err != nil {
+			t.Fatalf("could not encode file: %v", err)
+		}
+		if fromImage.String() != fromFile.String() {
+			t.Errorf("%v: EncodeImage output %q differs from Encode output %q", p, fromImage.String(), fromFile.String())
+		}
+	}
+}
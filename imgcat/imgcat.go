@@ -11,21 +11,24 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package imgcat provides a writer useful to show images directly into iterm2.
+// Package imgcat provides a writer useful to show images directly into iterm2
+// and other terminals speaking the kitty graphics protocol.
 // Tmux support works best using iterm2 tmux integration.
 package imgcat
 
 import (
-	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
+	"image"
 	"io"
-	"log"
-	"os"
-)
+	"strings"
 
-// An Option modifies how an image is displayed.
-type Option string
+	// Formats decoded by the protocols that need the image pixels.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
 
 // Length is used by the Width and Height options.
 type Length string
@@ -44,35 +47,23 @@ func Auto() Length { return Length("auto") }
 
 // Name sents the filename for the image. Defaults to "Unnamed file".
 func Name(name string) Option {
-	buf := new(bytes.Buffer)
-	enc := base64.NewEncoder(base64.StdEncoding, buf)
-	fmt.Fprint(enc, name)
-	if err := enc.Close(); err != nil {
-		log.Fatalf("could not encode to buffer: %v", err)
-	}
-	return Option(fmt.Sprintf("name=%s", buf))
+	value := base64.StdEncoding.EncodeToString([]byte(name))
+	return Option{"name", value, func(o *Options) { o.Name = name }}
 }
 
 // Size sets the file size in bytes. It's only used by the progress indicator.
 func Size(size int) Option {
-	return Option(fmt.Sprintf("size=%d", size))
+	return Option{"size", fmt.Sprint(size), func(o *Options) { o.Size = size }}
 }
 
 // Width to render, it can be in cells, pixels, percentage, or auto.
 func Width(l Length) Option {
-	return Option(fmt.Sprintf("width=%s", l))
+	return Option{"width", string(l), func(o *Options) { o.Width = l }}
 }
 
 // Height to render, it can be in cells, pixels, percentage, or auto.
 func Height(l Length) Option {
-	return Option(fmt.Sprintf("height=%s", l))
-}
-
-func boolToInt(b bool) int {
-	if b {
-		return 1
-	}
-	return 0
+	return Option{"height", string(l), func(o *Options) { o.Height = l }}
 }
 
 // PreserveAspectRatio set to false causes the image's inherent
@@ -80,7 +71,7 @@ func boolToInt(b bool) int {
 // specified width and height as much as possible without stretching.
 // Defaults to true.
 func PreserveAspectRatio(b bool) Option {
-	return Option(fmt.Sprintf("preserveAspectRatio=%d", boolToInt(b)))
+	return Option{"preserveAspectRatio", fmt.Sprint(boolToInt(b)), func(o *Options) { o.PreserveAspectRatio = &b }}
 }
 
 // Inline set to true causes the to be displayed inline.
@@ -88,7 +79,49 @@ func PreserveAspectRatio(b bool) Option {
 // representation in the terminal session.
 // Defaults to false.
 func Inline(b bool) Option {
-	return Option(fmt.Sprintf("inline=%d", boolToInt(b)))
+	return Option{"inline", fmt.Sprint(boolToInt(b)), func(o *Options) { o.Inline = &b }}
+}
+
+// A Protocol is a way of transmitting images to a terminal.
+type Protocol int
+
+const (
+	// ITerm2 is the OSC 1337 inline images protocol.
+	ITerm2 Protocol = iota
+	// Kitty is the kitty graphics protocol, also supported by WezTerm.
+	Kitty
+	// Sixel is the DEC Sixel graphics format, supported by xterm,
+	// foot, mlterm, and Windows Terminal among others.
+	Sixel
+	// Blocks draws images with unicode characters and ANSI colors,
+	// it works on any terminal.
+	Blocks
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ITerm2:
+		return "iTerm2"
+	case Kitty:
+		return "kitty"
+	case Sixel:
+		return "sixel"
+	case Blocks:
+		return "blocks"
+	default:
+		return fmt.Sprintf("Protocol(%d)", int(p))
+	}
+}
+
+// ParseProtocol returns the protocol with the given name, as returned by
+// String, ignoring case.
+func ParseProtocol(name string) (Protocol, error) {
+	for _, p := range []Protocol{ITerm2, Kitty, Sixel, Blocks} {
+		if strings.EqualFold(name, p.String()) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown protocol %q", name)
 }
 
 // IsSupported check whether imgcat works in the current terminal.
@@ -96,125 +129,242 @@ func IsSupported() bool { return isSupported() }
 
 // Can be swapped for testing.
 var isSupported = func() bool {
-	return os.Getenv("TERM_PROGRAM") == "iTerm.app"
+	_, ok := DetectProtocol()
+	return ok
 }
 
-// IsTmux checks whether we are in a tmux window.
+// IsTmux checks whether we are in a tmux window, as detected by
+// DetectMultiplexer.
 // tmux requires different escape code than iterm2 alone.
-// NOTE: If not using the iterm2 tmux integration (tmux -CC),
-// the prompt and cursor of tmux will not be aware of
-// the iterm2 sizing of the image and will be placed
-// over the image.
-func IsTmux() bool { return isTmux() }
-
-var isTmux = func() bool {
-	// for testing, set TMUX_TEST true/false
-	if os.Getenv("TMUX_TEST") == "false" {
-		return false
-	}
-	if os.Getenv("TMUX_TEST") == "true" {
-		return true
-	}
-	// otherwise, determine from TERM, TMUX variables
-	return (os.Getenv("TERM") == "screen" || len(os.Getenv("TMUX")) > 0)
+// As tmux doesn't know the size of the images it passes through,
+// encoders reserve the rows used by the images and move the cursor
+// below them, so the prompt isn't placed over the image.
+func IsTmux() bool {
+	m := DetectMultiplexer()
+	return m == Tmux || m == NestedTmux
 }
 
-//set the header string depending on whether we are in tmux.
-func headerEscape() string {
-	if IsTmux() {
-		return "\x1bPtmux;\x1b\x1b]1337;File="
+// NewEncoder returns a encoder that encodes images for the preferred
+// protocol of the current terminal, as returned by Detect.
+func NewEncoder(w io.Writer, options ...Option) (*Encoder, error) {
+	if !IsSupported() {
+		return nil, fmt.Errorf("imgcat is only supported with iTerm2, kitty, and sixel terminals")
 	}
-	return "\x1b]1337;File="
+	p, ok := DetectProtocol()
+	if !ok {
+		// Support was forced, default to the original protocol.
+		p = ITerm2
+	}
+	return NewProtocolEncoder(w, p, options...)
 }
 
-//set the header string depending on whether we are in tmux.
-func footerEscape() string {
-	if IsTmux() {
-		return "\a\x1b\\\n"
+// NewProtocolEncoder returns an encoder that encodes images for the given
+// protocol, regardless of what the current terminal supports.
+// Invalid options are reported when encoding.
+func NewProtocolEncoder(w io.Writer, p Protocol, options ...Option) (*Encoder, error) {
+	switch p {
+	case ITerm2, Kitty, Sixel, Blocks:
+	default:
+		return nil, fmt.Errorf("unknown protocol %v", p)
 	}
-	return "\a\n"
+	return &Encoder{out: w, options: options, protocol: p, kittyID: new(int)}, nil
 }
 
-// NewEncoder returns a encoder that encodes images for iterm2.
-func NewEncoder(w io.Writer, options ...Option) (*Encoder, error) {
-	if !IsSupported() {
-		return nil, fmt.Errorf("imgcat is only supported with iTerm2")
-	}
+// An Encoder is used to encode images to iterm2, kitty, sixel, or text.
+type Encoder struct {
+	out      io.Writer
+	options  []Option
+	protocol Protocol
+
+	// last kitty image id used by this encoder, shared with the copies
+	// made for calls with their own options.
+	kittyID *int
+	// reserved is set when the rows used by the image are already
+	// reserved in a multiplexer, or don't need to be.
+	reserved bool
+}
+
+// Protocol returns the protocol used by the encoder.
+func (enc *Encoder) Protocol() Protocol { return enc.protocol }
 
-	enc := &Encoder{out: w, options: options}
+// Options returns the options of the encoder, with later options
+// overriding earlier ones.
+func (enc *Encoder) Options() Options { return enc.opts() }
 
-	return enc, nil
+func (enc *Encoder) opts() Options { return Options{}.With(enc.options...) }
+
+// with returns a copy of the encoder whose options are overridden by the
+// given ones, failing if the result is not valid.
+func (enc *Encoder) with(options []Option) (*Encoder, error) {
+	e := enc
+	if len(options) > 0 {
+		call := *enc
+		call.options = append(enc.options[:len(enc.options):len(enc.options)], options...)
+		e = &call
+	}
+	if err := e.opts().Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
 }
 
-// An Encoder is used to encode images to iterm2.
-type Encoder struct {
-	out     io.Writer
-	options []Option
-}
-
-// Encode encodes the given image into the output.
-func (enc *Encoder) Encode(r io.Reader) error {
-	header := new(bytes.Buffer)
-	fmt.Fprint(header, headerEscape())
-	for i, option := range enc.options {
-		fmt.Fprintf(header, "%s", option)
-		if i < len(enc.options)-1 {
-			fmt.Fprintf(header, ";")
+// Encode encodes the given image into the output. The given options
+// override the ones of the encoder for this image only.
+func (enc *Encoder) Encode(r io.Reader, options ...Option) error {
+	enc, err := enc.with(options)
+	if err != nil {
+		return err
+	}
+	if enc.multiplexed() {
+		cfg, data, err := peekConfig(r)
+		if err == nil {
+			b := image.Rect(0, 0, cfg.Width, cfg.Height)
+			return enc.reserveRows(b, func(enc *Encoder) error { return enc.encode(data) })
 		}
+		// Not an image, it can still be downloaded by iTerm2.
+		r = data
 	}
-	fmt.Fprintf(header, ":")
-	pr, pw := io.Pipe()
-	go func() {
-		enc := base64.NewEncoder(base64.StdEncoding, pw)
-		defer func() {
-			if err := enc.Close(); err != nil {
-				// always returns nil according to specs.
-				_ = pw.CloseWithError(err)
+	return enc.encode(r)
+}
 
-			}
-		}()
+func (enc *Encoder) encode(r io.Reader) error {
+	switch enc.protocol {
+	case Sixel:
+		return enc.encodeSixel(r)
+	case Blocks:
+		return enc.encodeBlocks(r)
+	}
+	if enc.resizing() {
+		return enc.encodeResized(r)
+	}
+	return enc.encodeFile(r)
+}
 
-		_, err := io.Copy(enc, r)
-		if err != nil {
-			// always returns nil according to specs.
-			_ = pw.CloseWithError(err)
-		} else {
-			// always returns nil according to specs.
-			_ = pw.CloseWithError(enc.Close())
-		}
-	}()
+// encodeFile sends the file to iTerm2, or converted to PNG to kitty,
+// without its metadata when it's displayed unless the options say
+// otherwise. Files downloaded by iTerm2 are sent untouched.
+func (enc *Encoder) encodeFile(r io.Reader) error {
+	if o := enc.opts(); !o.KeepMetadata && o.Size == 0 && enc.displayed() {
+		r = stripMetadata(r)
+	}
+	if enc.protocol == Kitty {
+		return enc.encodeKitty(r)
+	}
+	return enc.encodeITerm2(r)
+}
+
+// displayed reports whether the terminal displays the file, rather than
+// iTerm2 downloading it.
+func (enc *Encoder) displayed() bool {
+	if enc.protocol != ITerm2 {
+		return true
+	}
+	o := enc.opts()
+	return o.Inline != nil && *o.Inline
+}
 
-	footer := bytes.NewBufferString(footerEscape())
+// encodeITerm2 sends the file with the encoder options.
+func (enc *Encoder) encodeITerm2(r io.Reader) error {
+	o := enc.opts()
+	if o.ChunkSize > 0 {
+		return enc.encodeMultipart(o.iTerm2Args(), r, o.ChunkSize)
+	}
 
-	_, err := io.Copy(enc.out, io.MultiReader(header, pr, footer))
+	sw, err := enc.multiplexer().stream(enc.out, "\x1b]1337;File="+o.iTerm2Args()+":", "\a")
+	if err != nil {
+		return err
+	}
+	b64 := base64.NewEncoder(base64.StdEncoding, sw)
+	_, err = io.Copy(b64, r)
+	if err == nil {
+		err = b64.Close()
+	}
+	// Send the footer even if the file could not be read, so the terminal
+	// doesn't take what's written next as part of it.
+	if ferr := sw.Close(); err == nil {
+		err = ferr
+	}
+	if _, ferr := io.WriteString(enc.out, "\n"); err == nil {
+		err = ferr
+	}
 	return err
 }
 
+// EncodeContext is like Encode, but stops reading the image once the
+// context is done, returning its error. A read that's blocked is not
+// interrupted. As with any other error, the part of the image already
+// sent is terminated so the terminal doesn't wait for the rest.
+func (enc *Encoder) EncodeContext(ctx context.Context, r io.Reader, options ...Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return enc.Encode(&contextReader{ctx, r}, options...)
+}
+
+// contextReader fails reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		// The read failed because the context is done, as when the pipe
+		// of a writer is closed.
+		err = r.ctx.Err()
+	}
+	return n, err
+}
+
 // Writer creates a writer that will encode whatever is written to it.
-func (enc *Encoder) Writer() io.WriteCloser {
+// The given options override the ones of the encoder for this image only.
+// Close must be called to release the resources of the writer.
+func (enc *Encoder) Writer(options ...Option) io.WriteCloser {
+	return enc.WriterContext(context.Background(), options...)
+}
+
+// WriterContext is like Writer, but stops encoding once the context is
+// done. Then, as when the encoding fails, the image is terminated and the
+// following calls to Write and Close return the error. The resources of
+// the writer are released once Close is called or the context is done.
+func (enc *Encoder) WriterContext(ctx context.Context, options ...Option) io.WriteCloser {
 	pr, pw := io.Pipe()
-	w := &writer{pw, make(chan struct{})}
+	w := &writer{pw: pw, done: make(chan struct{})}
 	go func() {
 		defer close(w.done)
-		if err := enc.Encode(pr); err != nil {
-			// always returns nil according to specs.
-			_ = pr.CloseWithError(err)
-		}
+		w.err = enc.EncodeContext(ctx, pr, options...)
+		// Fail the writes that won't be read, with nil meaning io.ErrClosedPipe.
+		_ = pr.CloseWithError(w.err) // always returns nil according to specs.
 	}()
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// Unblock the encoder if it's waiting for a write.
+				_ = pr.CloseWithError(ctx.Err()) // always returns nil according to specs.
+			case <-w.done:
+			}
+		}()
+	}
 	return w
 }
 
 type writer struct {
 	pw   *io.PipeWriter
 	done chan struct{}
+	// err is the result of the encoding, set before done is closed.
+	err error
 }
 
 func (w *writer) Write(p []byte) (int, error) { return w.pw.Write(p) }
 
+// Close waits for the encoding to finish, and returns its error.
 func (w *writer) Close() error {
-	if err := w.pw.Close(); err != nil {
-		return err
-	}
+	_ = w.pw.Close() // always returns nil according to specs.
 	<-w.done
-	return nil
+	return w.err
 }
@@ -0,0 +1,152 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/campoy/tools/imgcat"
+	"github.com/pkg/errors"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cells left empty between the columns of the grid.
+const gridGap = 1
+
+// A sheet lays out images in a grid of columns, one row at a time.
+type sheet struct {
+	enc     *imgcat.Encoder
+	columns int
+	// Size of a column in cells, and of a cell in pixels.
+	width, height         int
+	cellWidth, cellHeight int
+}
+
+// newSheet computes the size of the columns from the terminal size.
+func newSheet(enc *imgcat.Encoder, columns int) (*sheet, error) {
+	win, err := imgcat.TerminalWindow(200 * time.Millisecond)
+	if err != nil || win.Width == 0 {
+		// Not a terminal, assume the usual size.
+		win = imgcat.Window{Columns: 80, Rows: 24, Width: 640, Height: 384}
+	}
+	cw, ch := win.Cell()
+	width := (win.Columns - gridGap*(columns-1)) / columns
+	if width < 1 {
+		return nil, fmt.Errorf("%d columns do not fit in a terminal %d cells wide", columns, win.Columns)
+	}
+	// Square tiles, rounded to whole rows.
+	height := (width*cw + ch - 1) / ch
+	return &sheet{enc: enc, columns: columns, width: width, height: height, cellWidth: cw, cellHeight: ch}, nil
+}
+
+// show prints the images in rows, with their names and sizes underneath.
+// Files that can't be read are reported and skipped, returning how many.
+func (s *sheet) show(paths []string) (failed int) {
+	var row []image.Image
+	var names, sizes []string
+	flush := func() {
+		if len(row) == 0 {
+			return
+		}
+		if err := s.showRow(row, names, sizes); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			failed += len(row)
+		}
+		row, names, sizes = nil, nil, nil
+	}
+
+	for _, path := range paths {
+		m, err := decode(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			failed++
+			continue
+		}
+		b := m.Bounds()
+		row = append(row, m)
+		names = append(names, filepath.Base(path))
+		sizes = append(sizes, fmt.Sprintf("%dx%d", b.Dx(), b.Dy()))
+		if len(row) == s.columns {
+			flush()
+		}
+	}
+	flush()
+	return failed
+}
+
+func decode(path string) (image.Image, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, _, err := imgcat.DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode %s", path)
+	}
+	return m, nil
+}
+
+// showRow composes the images of a row in a single image, each one fit
+// and centered in its tile, and prints their captions.
+func (s *sheet) showRow(row []image.Image, names, sizes []string) error {
+	tw, th := s.width*s.cellWidth, s.height*s.cellHeight
+	gap := gridGap * s.cellWidth
+	cells := len(row)*s.width + (len(row)-1)*gridGap
+	canvas := image.NewNRGBA(image.Rect(0, 0, len(row)*(tw+gap)-gap, th))
+	for i, m := range row {
+		b := m.Bounds()
+		w, h := tw, b.Dy()*tw/b.Dx()
+		if h > th {
+			w, h = b.Dx()*th/b.Dy(), th
+		}
+		m = imgcat.Resize(m, max(1, w), max(1, h), imgcat.CatmullRom)
+		x, y := i*(tw+gap)+(tw-w)/2, (th-h)/2
+		draw.Draw(canvas, image.Rect(x, y, x+w, y+h), m, m.Bounds().Min, draw.Over)
+	}
+
+	err := s.enc.EncodeImage(canvas,
+		imgcat.Width(imgcat.Cells(cells)), imgcat.Height(imgcat.Cells(s.height)),
+		imgcat.Name(strings.Join(names, ", ")))
+	if err != nil {
+		return err
+	}
+	fmt.Println(s.caption(names))
+	fmt.Println(s.caption(sizes))
+	return nil
+}
+
+// caption returns a line with the texts aligned to the columns.
+func (s *sheet) caption(texts []string) string {
+	var line []string
+	for _, t := range texts {
+		if n := utf8.RuneCountInString(t); n > s.width {
+			t = string([]rune(t)[:s.width-1]) + "…"
+		}
+		line = append(line, t+strings.Repeat(" ", s.width-utf8.RuneCountInString(t)))
+	}
+	return strings.TrimRight(strings.Join(line, strings.Repeat(" ", gridGap)), " ")
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
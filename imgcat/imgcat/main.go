@@ -14,40 +14,271 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
+	"github.com/campoy/tools/flags"
 	"github.com/campoy/tools/imgcat"
 	"github.com/pkg/errors"
+	"image/color"
+	"image/gif"
+	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+var (
+	width    = flag.String("width", "100%", "width of the images in cells, pixels (px), percent (%), or auto")
+	height   = flag.String("height", "auto", "height of the images in cells, pixels (px), percent (%), or auto")
+	preserve = flag.Bool("preserve-aspect-ratio", true, "keep the aspect ratio of the images when both width and height are set")
+	protocol = flag.String("protocol", "auto", "protocol used to show the images: iTerm2, kitty, sixel, blocks, or auto to detect it")
+	name     = flag.String("name", "", "name of the images, defaults to the file names")
+	exts     = flag.String("ext", ".png,.jpg,.jpeg,.gif", "comma separated extensions of the files shown from directories")
+	loop     = flag.Int("loop", 0, "times animated GIFs are played, 0 uses the count in the file and -1 loops forever")
+	fps      = flag.Float64("fps", 0, "frames per second of animated GIFs, 0 uses the delays in the file")
+	grid     = flag.Int("grid", 0, "show the images in a grid of this many columns, captioned with their names and sizes")
+	glyphs   = flag.String("glyphs", "", "draw the images as text with half, quadrants, braille, ascii, or ascii-edges characters")
+	colors   = flag.String("colors", "true", "colors of the images drawn as text: true, 256, 16, or none")
+	aspect   = flag.Bool("aspect", true, "correct the height of images drawn as text for cells taller than wide")
+	bg       = flag.String("bg", "auto", "color transparent images are drawn over with sixel and text: a hex color, none, or auto to ask the terminal")
+	mux      = flag.String("multiplexer", "auto", "multiplexer the images are passed through: none, tmux, tmux-nested for tmux in tmux, screen, or auto to detect it")
+)
+
+// depths are the values of the -colors flag.
+var depths = map[string]imgcat.ColorDepth{
+	"true": imgcat.TrueColor,
+	"256":  imgcat.Colors256,
+	"16":   imgcat.Colors16,
+	"none": imgcat.Monochrome,
+}
+
+// multiplexers are the values of the -multiplexer flag, other than auto.
+var multiplexers = map[string]imgcat.Multiplexer{
+	"none":        imgcat.NoMultiplexer,
+	"tmux":        imgcat.Tmux,
+	"tmux-nested": imgcat.NestedTmux,
+	"screen":      imgcat.Screen,
+}
+
+func init() {
+	flag.StringVar(width, "w", *width, "shorthand for -width")
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "usage:\n\t%s [image_path]*\n", os.Args[0])
-		os.Exit(1)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage:\n\t%s [flags] [image_path|directory|-]*\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Reads an image from the standard input if no paths are given.\n")
+		fmt.Fprintf(os.Stderr, "Use -glyphs ascii -colors none -width 80 to write images to logs and files.\n")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	enc, err := imgcat.NewEncoder(os.Stdout,
-		imgcat.Inline(true),
-		imgcat.Width(imgcat.Percent(100)))
+	enc, err := newEncoder()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
 
-	for _, path := range os.Args[1:] {
-		if err := cat(enc, path); err != nil {
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	paths, errs := imgcat.ExpandPaths(args, strings.Split(*exts, ","))
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	failed := len(errs)
+
+	if *grid > 0 {
+		s, err := newSheet(enc, *grid)
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
 		}
+		failed += s.show(paths)
+		os.Exit(summary(failed, len(paths)))
 	}
+
+	for _, path := range paths {
+		err := cat(enc, path)
+		if err == context.Canceled {
+			// The animation was interrupted.
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			failed++
+		}
+	}
+	os.Exit(summary(failed, len(paths)))
 }
 
-func cat(enc *imgcat.Encoder, path string) error {
-	f, err := os.Open(path)
+// newEncoder returns an encoder for the protocol and options given as flags.
+func newEncoder() (*imgcat.Encoder, error) {
+	w, err := imgcat.ParseLength(*width)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad width")
+	}
+	h, err := imgcat.ParseLength(*height)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad height")
+	}
+	options := []imgcat.Option{imgcat.Inline(true), imgcat.Width(w), imgcat.PreserveAspectRatio(*preserve)}
+	if h != imgcat.Auto() {
+		options = append(options, imgcat.Height(h))
+	}
+	if *name != "" {
+		options = append(options, imgcat.Name(*name))
+	}
+	depth, ok := depths[*colors]
+	if !ok {
+		return nil, errors.Errorf("unknown colors %q", *colors)
+	}
+	options = append(options, imgcat.Depth(depth))
+	if *glyphs != "" {
+		options = append(options, imgcat.GlyphSet(imgcat.Glyphs(*glyphs)))
+	}
+	if *mux != "auto" {
+		m, ok := multiplexers[*mux]
+		if !ok {
+			return nil, errors.Errorf("unknown multiplexer %q", *mux)
+		}
+		options = append(options, imgcat.Multiplexed(m))
+	}
+	if _, err := imgcat.NewOptions(options...); err != nil {
+		return nil, err
+	}
+
+	p, err := chooseProtocol()
+	if err != nil {
+		return nil, err
+	}
+	c, err := background(p)
 	if err != nil {
-		return errors.Wrapf(err, "could not open %s", path)
+		return nil, err
 	}
-	if err := enc.Encode(f); err != nil {
+	if c != nil {
+		options = append(options, imgcat.Background(c))
+	}
+	if !*aspect && p == imgcat.Blocks {
+		// Square cells, so a pixel is drawn per row of text. The other
+		// protocols need the real cell size to convert lengths in cells.
+		options = append(options, imgcat.CellSize(1, 1))
+	}
+	return imgcat.NewProtocolEncoder(os.Stdout, p, options...)
+}
+
+// chooseProtocol returns the protocol given with -protocol, or the one
+// detected for the terminal.
+func chooseProtocol() (imgcat.Protocol, error) {
+	if *protocol != "auto" {
+		return imgcat.ParseProtocol(*protocol)
+	}
+	if !imgcat.IsSupported() || *glyphs != "" {
+		// Draw the images as text in terminals without graphics support.
+		return imgcat.Blocks, nil
+	}
+	if p, ok := imgcat.DetectProtocol(); ok {
+		return p, nil
+	}
+	// Support was forced, default to the original protocol.
+	return imgcat.ITerm2, nil
+}
+
+// How long to wait for the terminal to report its background color.
+const queryTimeout = 200 * time.Millisecond
+
+// background returns the color given with -bg, if any. For auto, it asks
+// the terminal for its background when the protocol can't draw partially
+// transparent pixels and the images are shown in a terminal.
+func background(p imgcat.Protocol) (color.Color, error) {
+	switch *bg {
+	case "none":
+		return nil, nil
+	case "auto":
+		if p != imgcat.Sixel && p != imgcat.Blocks {
+			return nil, nil
+		}
+		if _, err := imgcat.WindowSize(os.Stdout); err != nil {
+			// Not a terminal, the images are written to a file.
+			return nil, nil
+		}
+		// Terminals that don't answer get no background.
+		c, _ := imgcat.TerminalBackground(queryTimeout)
+		return c, nil
+	}
+	c, err := flags.ParseHexColor(*bg)
+	return c, errors.Wrap(err, "bad background")
+}
+
+// summary reports how many files failed and returns the exit code.
+func summary(failed, total int) int {
+	if failed == 0 {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d files could not be shown\n", failed, total)
+	return 1
+}
+
+// readFile reads the file with the given path, or the standard input for "-".
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		return data, errors.Wrap(err, "could not read standard input")
+	}
+	data, err := ioutil.ReadFile(path)
+	return data, errors.Wrapf(err, "could not open %s", path)
+}
+
+func cat(enc *imgcat.Encoder, path string) error {
+	data, err := readFile(path)
+	if err != nil {
 		return err
 	}
-	return f.Close()
+	var options []imgcat.Option
+	if *name == "" && path != "-" {
+		options = append(options, imgcat.Name(filepath.Base(path)))
+	}
+	if animate(enc) && bytes.HasPrefix(data, []byte("GIF8")) {
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrapf(err, "could not decode %s", path)
+		}
+		if len(g.Image) > 1 {
+			return play(enc, g)
+		}
+	}
+	return errors.Wrapf(enc.Encode(bytes.NewReader(data), options...), "could not show %s", path)
+}
+
+// play plays the animation, stopping it on interrupt so the cursor is
+// restored, in which case it returns context.Canceled. The interrupt is
+// only caught while playing, so reading the images can be interrupted.
+func play(enc *imgcat.Encoder, g *gif.GIF) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	p := imgcat.NewPlayer(enc)
+	p.Loops, p.FPS = *loop, *fps
+	return p.Play(ctx, g)
+}
+
+// animate reports whether animations should be played frame by frame
+// rather than by the terminal.
+func animate(enc *imgcat.Encoder) bool {
+	return enc.Protocol() != imgcat.ITerm2 || enc.Multiplexer() != imgcat.NoMultiplexer || *loop != 0 || *fps != 0
 }
@@ -2,10 +2,15 @@ package imgcat
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestIsSupported(t *testing.T) {
@@ -162,6 +167,150 @@ func TestBadWriter(t *testing.T) {
 	}
 }
 
+// checkGoroutines fails if the number of goroutines doesn't go back to n
+// in a second, showing the ones running.
+func checkGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("leaked %d goroutines:\n%s", runtime.NumGoroutine()-n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// interruptedReader reads the first n bytes of data, and then calls
+// interrupt and fails with the error it returns.
+type interruptedReader struct {
+	data      []byte
+	n         int
+	interrupt func() error
+	read      int
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.read >= r.n {
+		return 0, r.interrupt()
+	}
+	n := copy(p, r.data[r.read:r.n])
+	r.read += n
+	return n, nil
+}
+
+func TestEncodeContext(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 256, 256)
+	errSource := errors.New("bad source")
+	tc := []struct {
+		name     string
+		protocol Protocol
+		options  []Option
+		// end of the output once the image is terminated.
+		end string
+	}{
+		{"iTerm2", ITerm2, nil, "\a\n"},
+		{"multipart", ITerm2, []Option{ChunkSize(1024)}, "\x1b]1337;FileEnd\a"},
+		{"kitty", Kitty, nil, "\x1b_Gm=0;\x1b\\"},
+	}
+	for _, tt := range tc {
+		n := runtime.NumGoroutine()
+
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, tt.protocol, tt.options...)
+		check(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		r := &interruptedReader{data: img, n: len(img) / 2, interrupt: func() error {
+			cancel()
+			return nil
+		}}
+		if err := enc.EncodeContext(ctx, r); err != context.Canceled {
+			t.Errorf("%s: expected context.Canceled, got %v", tt.name, err)
+		}
+		if got := buf.String(); !strings.HasSuffix(got, tt.end) {
+			t.Errorf("%s: canceled image ends with %q; want %q", tt.name, got[len(got)-20:], tt.end)
+		}
+		if err := enc.EncodeContext(ctx, bytes.NewReader(img)); err != context.Canceled {
+			t.Errorf("%s: expected context.Canceled with a canceled context, got %v", tt.name, err)
+		}
+
+		buf.Reset()
+		r = &interruptedReader{data: img, n: len(img) / 2, interrupt: func() error { return errSource }}
+		if err := enc.Encode(r); err != errSource {
+			t.Errorf("%s: expected the source error, got %v", tt.name, err)
+		}
+		if got := buf.String(); !strings.HasSuffix(got, tt.end) {
+			t.Errorf("%s: failed image ends with %q; want %q", tt.name, got[len(got)-20:], tt.end)
+		}
+		checkGoroutines(t, n)
+	}
+}
+
+func TestEncodeStopsOnWriteError(t *testing.T) {
+	n := runtime.NumGoroutine()
+	enc, err := NewProtocolEncoder(badWriter{}, ITerm2)
+	check(t, err)
+	r := &interruptedReader{data: []byte("test"), n: 4, interrupt: func() error { return io.EOF }}
+	if err := enc.Encode(r); err == nil || err.Error() != "bad writer" {
+		t.Errorf("expected error bad writer; got %v", err)
+	}
+	if r.read > 0 {
+		t.Errorf("read %d bytes after failing to write", r.read)
+	}
+	checkGoroutines(t, n)
+}
+
+func TestWriterContext(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+	n := runtime.NumGoroutine()
+
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2)
+	check(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := enc.WriterContext(ctx)
+	if _, err := fmt.Fprint(w, "test"); err != nil {
+		t.Fatalf("could not write: %v", err)
+	}
+	// Close is never called, canceling is enough.
+	cancel()
+	checkGoroutines(t, n)
+
+	if _, err := fmt.Fprint(w, "more"); err != context.Canceled {
+		t.Errorf("expected context.Canceled writing, got %v", err)
+	}
+	if err := w.Close(); err != context.Canceled {
+		t.Errorf("expected context.Canceled closing, got %v", err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "\x1b]1337;File=:") || !strings.HasSuffix(got, "\a\n") {
+		t.Errorf("expected a terminated image, got %q", got)
+	}
+}
+
+func TestWriterCloseReportsError(t *testing.T) {
+	n := runtime.NumGoroutine()
+	enc, err := NewProtocolEncoder(badWriter{}, ITerm2)
+	check(t, err)
+	// The cancelable context is read by a goroutine that must end too.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		w := enc.WriterContext(ctx)
+		if _, err := fmt.Fprint(w, "test"); err == nil || err.Error() != "bad writer" {
+			t.Errorf("expected error bad writer writing; got %v", err)
+		}
+		if err := w.Close(); err == nil || err.Error() != "bad writer" {
+			t.Errorf("expected error bad writer closing; got %v", err)
+		}
+	}
+	checkGoroutines(t, n)
+}
+
 func check(t *testing.T, err error) {
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
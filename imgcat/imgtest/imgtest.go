@@ -0,0 +1,262 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package imgtest compares images with golden PNG files in tests, and
+// other output, such as escape sequences, with golden files.
+// When an image doesn't match, the actual, expected, and diff images are
+// written next to the golden file and, if the terminal supports it, shown
+// side by side with imgcat.
+//
+// Run the tests with -update to rewrite the golden files. Packages using
+// imgtest share its -update flag, and should not define their own.
+package imgtest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// A Checker compares images with golden files.
+type Checker struct {
+	// Tolerance is the largest difference allowed in any channel of a
+	// pixel, from 0 to 255.
+	Tolerance uint8
+	// Dir is where the images are written on failure. Defaults to the
+	// directory of the golden file.
+	Dir string
+	// Encoder shows the images on failure. Defaults to an encoder for the
+	// standard output if the terminal supports images.
+	Encoder *imgcat.Encoder
+}
+
+// Check compares got with the golden PNG file with no tolerance.
+func Check(t testing.TB, golden string, got image.Image) bool {
+	return (&Checker{}).Check(t, golden, got)
+}
+
+// Check compares got with the golden PNG file, reporting an error to t if
+// they differ, and rewrites the golden file instead when -update is set.
+// It returns whether the images match.
+func (c *Checker) Check(t testing.TB, golden string, got image.Image) bool {
+	t.Helper()
+	if *update {
+		if err := writePNG(golden, got); err != nil {
+			t.Errorf("could not update golden image: %v", err)
+			return false
+		}
+		return true
+	}
+
+	want, err := readPNG(golden)
+	if err != nil {
+		t.Errorf("could not read golden image: %v (run with -update to create it)", err)
+		return false
+	}
+	diff, n := Diff(got, want, c.Tolerance)
+	if n == 0 {
+		return true
+	}
+
+	msg := fmt.Sprintf("image does not match %s: %d pixels differ", golden, n)
+	if gs, ws := got.Bounds().Size(), want.Bounds().Size(); gs != ws {
+		msg = fmt.Sprintf("image does not match %s: size %v, want %v", golden, gs, ws)
+	}
+	paths, err := c.save(golden, got, want, diff)
+	if err != nil {
+		t.Errorf("%s; could not save images: %v", msg, err)
+	} else {
+		t.Errorf("%s; see %s", msg, strings.Join(paths, ", "))
+	}
+	if err := c.show(want, got, diff); err != nil {
+		t.Logf("could not show images: %v", err)
+	}
+	return false
+}
+
+// Golden compares got with the contents of the golden file, reporting an
+// error to t if they differ, and rewrites the golden file instead when
+// -update is set. It returns whether the contents match.
+func Golden(t testing.TB, golden string, got []byte) bool {
+	t.Helper()
+	if *update {
+		err := os.MkdirAll(filepath.Dir(golden), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(golden, got, 0644)
+		}
+		if err != nil {
+			t.Errorf("could not update golden file: %v", err)
+			return false
+		}
+		return true
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Errorf("could not read golden file: %v (run with -update to create it)", err)
+		return false
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s:\ngot  %q\nwant %q", golden, got, want)
+		return false
+	}
+	return true
+}
+
+// save writes the actual, expected, and diff images and returns their paths.
+func (c *Checker) save(golden string, got, want, diff image.Image) ([]string, error) {
+	dir := c.Dir
+	if dir == "" {
+		dir = filepath.Dir(golden)
+	}
+	base := strings.TrimSuffix(filepath.Base(golden), filepath.Ext(golden))
+	var paths []string
+	for _, f := range []struct {
+		suffix string
+		m      image.Image
+	}{{"actual", got}, {"expected", want}, {"diff", diff}} {
+		path := filepath.Join(dir, base+"."+f.suffix+".png")
+		if err := writePNG(path, f.m); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// show prints the expected, actual, and diff images side by side.
+func (c *Checker) show(want, got, diff image.Image) error {
+	enc := c.Encoder
+	if enc == nil {
+		if !imgcat.IsSupported() {
+			return nil
+		}
+		var err error
+		enc, err = imgcat.NewEncoder(os.Stdout, imgcat.Inline(true))
+		if err != nil {
+			return err
+		}
+	}
+	return enc.EncodeImage(SideBySide(want, got, diff))
+}
+
+// Diff compares two images pixel by pixel. It returns an image that shows
+// the pixels that differ more than the tolerance in red over a faded copy
+// of want, and how many of them there are. Pixels out of either image
+// always differ.
+func Diff(got, want image.Image, tolerance uint8) (*image.NRGBA, int) {
+	gb, wb := got.Bounds(), want.Bounds()
+	w, h := max(gb.Dx(), wb.Dx()), max(gb.Dy(), wb.Dy())
+	diff := image.NewNRGBA(image.Rect(0, 0, w, h))
+	n := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gp, wp := image.Pt(gb.Min.X+x, gb.Min.Y+y), image.Pt(wb.Min.X+x, wb.Min.Y+y)
+			if !gp.In(gb) || !wp.In(wb) || !similar(got.At(gp.X, gp.Y), want.At(wp.X, wp.Y), tolerance) {
+				diff.SetNRGBA(x, y, color.NRGBA{0xff, 0, 0, 0xff})
+				n++
+				continue
+			}
+			g := color.GrayModel.Convert(want.At(wp.X, wp.Y)).(color.Gray)
+			diff.SetNRGBA(x, y, color.NRGBA{g.Y, g.Y, g.Y, 0x40})
+		}
+	}
+	return diff, n
+}
+
+// similar reports whether no channel of the colors differs by more than the
+// tolerance.
+func similar(a, b color.Color, tolerance uint8) bool {
+	ca, cb := color.RGBAModel.Convert(a).(color.RGBA), color.RGBAModel.Convert(b).(color.RGBA)
+	for _, d := range []int{
+		int(ca.R) - int(cb.R), int(ca.G) - int(cb.G),
+		int(ca.B) - int(cb.B), int(ca.A) - int(cb.A),
+	} {
+		if d > int(tolerance) || -d > int(tolerance) {
+			return false
+		}
+	}
+	return true
+}
+
+// Gap in pixels between the images drawn by SideBySide.
+const gap = 4
+
+// SideBySide returns the images drawn next to each other, left to right,
+// aligned to the top.
+func SideBySide(ms ...image.Image) *image.NRGBA {
+	w, h := 0, 0
+	for i, m := range ms {
+		if i > 0 {
+			w += gap
+		}
+		w += m.Bounds().Dx()
+		h = max(h, m.Bounds().Dy())
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	x := 0
+	for _, m := range ms {
+		b := m.Bounds()
+		draw.Draw(dst, image.Rect(x, 0, x+b.Dx(), b.Dy()), m, b.Min, draw.Src)
+		x += b.Dx() + gap
+	}
+	return dst
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s: %v", path, err)
+	}
+	return m, nil
+}
+
+func writePNG(path string, m image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, m); err != nil {
+		_ = f.Close() // the encoding error is more relevant.
+		return fmt.Errorf("could not encode %s: %v", path, err)
+	}
+	return f.Close()
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,165 @@
+package imgtest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+// fakeT records the errors reported by the checker.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Logf(format string, args ...interface{}) {}
+
+func uniform(w, h int, c color.Color) *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.Set(x, y, c)
+		}
+	}
+	return m
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "imgtest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	return dir
+}
+
+func TestDiff(t *testing.T) {
+	want := uniform(4, 2, color.NRGBA{100, 100, 100, 0xff})
+	got := uniform(4, 2, color.NRGBA{104, 100, 100, 0xff})
+	got.Set(1, 1, color.NRGBA{200, 0, 0, 0xff})
+
+	tc := []struct {
+		tolerance uint8
+		n         int
+	}{{0, 8}, {4, 1}, {255, 0}}
+	for _, tt := range tc {
+		diff, n := Diff(got, want, tt.tolerance)
+		if n != tt.n {
+			t.Errorf("tolerance %d: expected %d different pixels; got %d", tt.tolerance, tt.n, n)
+		}
+		if tt.n == 1 && diff.NRGBAAt(1, 1) != (color.NRGBA{0xff, 0, 0, 0xff}) {
+			t.Errorf("expected different pixel in red; got %v", diff.NRGBAAt(1, 1))
+		}
+	}
+
+	// Sub images are compared from their origin, other sizes always differ.
+	if _, n := Diff(got.SubImage(image.Rect(2, 0, 4, 2)), want.SubImage(image.Rect(0, 0, 2, 2)), 4); n != 0 {
+		t.Errorf("expected sub images to match; got %d different pixels", n)
+	}
+	if diff, n := Diff(uniform(2, 1, color.White), uniform(1, 2, color.White), 0); n != 3 || diff.Bounds().Size() != image.Pt(2, 2) {
+		t.Errorf("expected 3 different pixels in a 2x2 diff; got %d in %v", n, diff.Bounds())
+	}
+}
+
+func TestCheck(t *testing.T) {
+	defer func(old bool) { *update = old }(*update)
+	*update = false
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	golden := filepath.Join(dir, "testdata", "gray.png")
+	want := uniform(4, 2, color.NRGBA{100, 100, 100, 0xff})
+
+	ft := new(fakeT)
+	if Check(ft, golden, want) || len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "-update") {
+		t.Fatalf("expected error suggesting -update for a missing golden; got %q", ft.errors)
+	}
+
+	*update = true
+	ok := Check(ft, golden, want)
+	*update = false
+	if !ok {
+		t.Fatalf("could not update golden: %q", ft.errors)
+	}
+
+	ft = new(fakeT)
+	if !Check(ft, golden, want) || len(ft.errors) > 0 {
+		t.Errorf("expected golden to match; got %q", ft.errors)
+	}
+
+	got := uniform(4, 2, color.NRGBA{102, 100, 100, 0xff})
+	if !(&Checker{Tolerance: 2}).Check(ft, golden, got) {
+		t.Errorf("expected golden to match within tolerance; got %q", ft.errors)
+	}
+
+	out := tempDir(t)
+	defer os.RemoveAll(out)
+	var buf bytes.Buffer
+	enc, err := imgcat.NewProtocolEncoder(&buf, imgcat.Blocks)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	c := &Checker{Tolerance: 1, Dir: out, Encoder: enc}
+	if c.Check(ft, golden, got) || len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "8 pixels differ") {
+		t.Fatalf("expected 8 different pixels; got %q", ft.errors)
+	}
+	for _, name := range []string{"gray.actual.png", "gray.expected.png", "gray.diff.png"} {
+		if _, err := readPNG(filepath.Join(out, name)); err != nil {
+			t.Errorf("could not read %s: %v", name, err)
+		}
+	}
+	if buf.Len() == 0 {
+		t.Errorf("expected the images to be shown")
+	}
+}
+
+func TestGolden(t *testing.T) {
+	defer func(old bool) { *update = old }(*update)
+	*update = false
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	golden := filepath.Join(dir, "testdata", "seq.txt")
+	want := []byte("\x1b]1337;SetMark\a")
+
+	ft := new(fakeT)
+	if Golden(ft, golden, want) || len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "-update") {
+		t.Fatalf("expected error suggesting -update for a missing golden; got %q", ft.errors)
+	}
+
+	*update = true
+	ok := Golden(ft, golden, want)
+	*update = false
+	if !ok {
+		t.Fatalf("could not update golden: %q", ft.errors)
+	}
+
+	ft = new(fakeT)
+	if !Golden(ft, golden, want) || len(ft.errors) > 0 {
+		t.Errorf("expected golden to match; got %q", ft.errors)
+	}
+	if Golden(ft, golden, []byte("other")) || len(ft.errors) != 1 {
+		t.Errorf("expected golden not to match; got %q", ft.errors)
+	}
+}
+
+func TestSideBySide(t *testing.T) {
+	m := SideBySide(uniform(2, 3, color.White), uniform(1, 1, color.Black))
+	if got, want := m.Bounds().Size(), image.Pt(2+gap+1, 3); got != want {
+		t.Fatalf("expected size %v; got %v", want, got)
+	}
+	if m.NRGBAAt(2+gap, 0) != (color.NRGBA{0, 0, 0, 0xff}) || m.NRGBAAt(2+gap, 1).A != 0 {
+		t.Errorf("second image not drawn at the top right")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/campoy/tools/imgcat"
+	"github.com/campoy/tools/imgcat/viewer"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+var (
+	protocol = flag.String("protocol", "auto", "protocol used to show the images: iTerm2, kitty, sixel, blocks, or auto to detect it")
+	exts     = flag.String("ext", ".png,.jpg,.jpeg,.gif", "comma separated extensions of the files shown from directories")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage:\n\t%s [flags] [image_path|directory]+\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Shows the images full screen. Press q to quit, and see the other keys in the status line.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	paths, errs := imgcat.ExpandPaths(flag.Args(), strings.Split(*exts, ","))
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	if err := run(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("no images found")
+	}
+	tty, err := imgcat.OpenTTY()
+	if err != nil {
+		return errors.Wrap(err, "could not open terminal")
+	}
+	defer tty.Close()
+
+	enc, err := newEncoder(tty)
+	if err != nil {
+		return err
+	}
+	v := viewer.New(&terminal{TTY: tty}, enc, paths)
+
+	resize, quit := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	notifyResize(resize)
+	signal.Notify(quit, os.Interrupt)
+	defer signal.Stop(resize)
+	defer signal.Stop(quit)
+	v.Resize, v.Quit = resize, quit
+
+	return v.Run()
+}
+
+func newEncoder(tty *imgcat.TTY) (*imgcat.Encoder, error) {
+	if *protocol != "auto" {
+		p, err := imgcat.ParseProtocol(*protocol)
+		if err != nil {
+			return nil, err
+		}
+		return imgcat.NewProtocolEncoder(tty, p, imgcat.Inline(true))
+	}
+	if !imgcat.IsSupported() {
+		// Draw the images as text in terminals without graphics support.
+		return imgcat.NewProtocolEncoder(tty, imgcat.Blocks)
+	}
+	return imgcat.NewEncoder(tty, imgcat.Inline(true))
+}
+
+// terminal is the controlling terminal, as seen by the viewer.
+type terminal struct {
+	*imgcat.TTY
+	// pixels is the size in pixels of the terminal when the kernel doesn't
+	// know it, as last queried.
+	pixels imgcat.Window
+}
+
+// Read returns no data when no key is typed for a tenth of a second, which
+// the TTY in raw mode reports as the end of the file.
+func (t *terminal) Read(p []byte) (int, error) {
+	n, err := t.TTY.Read(p)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// How long to wait for the terminal to report its size in pixels.
+const queryTimeout = 200 * time.Millisecond
+
+// Size returns the size reported by the kernel, and queries the terminal
+// for the size in pixels if the kernel doesn't know it.
+func (t *terminal) Size() (imgcat.Window, error) {
+	win, err := imgcat.WindowSize(t.File)
+	if err != nil || win.Width > 0 {
+		return win, err
+	}
+	if t.pixels.Columns != win.Columns || t.pixels.Rows != win.Rows {
+		q, err := imgcat.QueryWindow(t, queryTimeout)
+		if err != nil {
+			// Leave the defaults of the viewer.
+			return win, nil
+		}
+		t.pixels = q
+	}
+	win.Width, win.Height = t.pixels.Width, t.pixels.Height
+	return win, nil
+}
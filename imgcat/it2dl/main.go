@@ -0,0 +1,88 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/campoy/tools/imgcat"
+	"github.com/campoy/tools/imgcat/transfer"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+var (
+	name  = flag.String("name", "", "name of the downloaded files, defaults to the file names or stdin")
+	chunk = flag.Int("chunk", 0, "send the files in parts of this many bytes, for large files in tmux")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage:\n\t%s [flags] [path|-]*\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Downloads the files to the local machine through iTerm2, or the standard input if no paths are given.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	failed := 0
+	for _, path := range paths {
+		if err := download(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files could not be downloaded\n", failed, len(paths))
+		os.Exit(1)
+	}
+}
+
+// download sends the file with the given path, or the standard input for
+// "-", with its size so iTerm2 shows the progress.
+func download(path string) error {
+	var options []imgcat.Option
+	if *chunk > 0 {
+		options = append(options, imgcat.ChunkSize(*chunk))
+	}
+	if path == "-" {
+		return errors.Wrap(transfer.Download(os.Stdout, os.Stdin, fileName("stdin"), 0, options...), "could not download standard input")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s", path)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s", path)
+	}
+	if fi.IsDir() {
+		return errors.Errorf("could not download %s: is a directory", path)
+	}
+	return errors.Wrapf(transfer.Download(os.Stdout, f, fileName(filepath.Base(path)), fi.Size(), options...), "could not download %s", path)
+}
+
+// fileName returns the name given with -name, or the default one.
+func fileName(def string) string {
+	if *name != "" {
+		return *name
+	}
+	return def
+}
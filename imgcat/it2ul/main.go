@@ -0,0 +1,90 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/campoy/tools/imgcat"
+	"github.com/campoy/tools/imgcat/transfer"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"os/signal"
+)
+
+var dir = flag.String("dir", ".", "directory where the uploaded files are extracted")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage:\n\t%s [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Asks iTerm2 for files to upload from the local machine, and extracts them in a directory.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	tty, err := imgcat.OpenTTY()
+	if err != nil {
+		return errors.Wrap(err, "could not open terminal")
+	}
+	defer tty.Close()
+
+	// The terminal is in raw mode, but interrupts still raise a signal.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	defer signal.Stop(quit)
+
+	names, err := transfer.Upload(&terminal{TTY: tty, quit: quit}, *dir)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	if err == transfer.ErrAborted {
+		return errors.New("upload cancelled")
+	}
+	return errors.Wrap(err, "could not upload")
+}
+
+// terminal waits for the upload, which may take as long as the user needs
+// to pick the files, until interrupted.
+type terminal struct {
+	*imgcat.TTY
+	quit <-chan os.Signal
+}
+
+// Read waits until there is some input, as the TTY in raw mode reports the
+// end of the file when there's none for a tenth of a second.
+func (t *terminal) Read(p []byte) (int, error) {
+	for {
+		n, err := t.TTY.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-t.quit:
+			return 0, errors.New("interrupted")
+		default:
+		}
+	}
+}
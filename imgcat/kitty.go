@@ -0,0 +1,183 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// kittyChunkSize is the maximum size of the base64 payload of a single
+// kitty graphics command, as specified by the protocol.
+const kittyChunkSize = 4096
+
+var pngMagic = []byte("\x89PNG\r\n\x1a\n")
+
+// encodeKitty transmits and displays the image using the kitty graphics
+// protocol. Images that are not PNG are decoded and converted to PNG.
+func (enc *Encoder) encodeKitty(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(pngMagic)); !bytes.Equal(magic, pngMagic) {
+		m, _, err := DecodeImage(br)
+		if err != nil {
+			return fmt.Errorf("could not decode image: %v", err)
+		}
+		buf := new(bytes.Buffer)
+		if err := png.Encode(buf, m); err != nil {
+			return fmt.Errorf("could not encode image as png: %v", err)
+		}
+		br = bufio.NewReader(buf)
+	}
+
+	// The PNG header and IHDR chunk are enough to know the image size.
+	var cfg image.Config
+	if head, _ := br.Peek(33); len(head) == 33 {
+		cfg, _ = png.DecodeConfig(bytes.NewReader(head))
+	}
+
+	return enc.sendKitty([]string{"f=100"}, cfg, br)
+}
+
+// sendKitty transmits and displays the given payload, described by the
+// given keys, as a new image.
+func (enc *Encoder) sendKitty(format []string, cfg image.Config, payload io.Reader) error {
+	keys := append([]string{"a=T"}, format...)
+	keys = append(keys, "q=2", fmt.Sprintf("i=%d", enc.nextKittyID()), "p=1")
+	keys = append(keys, enc.kittySize(cfg)...)
+	if err := enc.transmitKitty(keys, payload); err != nil {
+		return err
+	}
+	_, err := fmt.Fprint(enc.out, "\n")
+	return err
+}
+
+// transmitKitty sends the payload in chunks, with the given control keys.
+func (enc *Encoder) transmitKitty(keys []string, payload io.Reader) error {
+	cw := &kittyChunker{w: enc.out, control: strings.Join(keys, ","), mux: enc.multiplexer()}
+	b64 := base64.NewEncoder(base64.StdEncoding, cw)
+	_, err := io.Copy(b64, payload)
+	if err == nil {
+		err = b64.Close()
+	}
+	if err != nil {
+		// The first error is more relevant.
+		_ = cw.abort()
+		return err
+	}
+	return cw.Close()
+}
+
+// nextKittyID returns a new id for an image sent by the encoder.
+func (enc *Encoder) nextKittyID() int {
+	if enc.kittyID == nil {
+		enc.kittyID = new(int)
+	}
+	*enc.kittyID++
+	return *enc.kittyID
+}
+
+// kittySize maps the Width, Height, and PreserveAspectRatio options to
+// kitty's columns and rows keys, converting lengths in pixels and percent
+// to cells. When both are given and the aspect ratio must be preserved
+// only the most restrictive one is kept, letting kitty compute the other
+// one.
+func (enc *Encoder) kittySize(cfg image.Config) []string {
+	o := enc.opts()
+	win := enc.window()
+	cols, err := win.Cells(o.Width, Horizontal)
+	okc := err == nil && cols > 0
+	rows, err := win.Cells(o.Height, Vertical)
+	okr := err == nil && rows > 0
+	if okc && okr && o.preserveAspectRatio() && cfg.Width > 0 && cfg.Height > 0 {
+		cw, ch := win.Cell()
+		sx := float64(cols*cw) / float64(cfg.Width)
+		sy := float64(rows*ch) / float64(cfg.Height)
+		if sx < sy {
+			okr = false
+		} else {
+			okc = false
+		}
+	}
+	var keys []string
+	if okc {
+		keys = append(keys, fmt.Sprintf("c=%d", cols))
+	}
+	if okr {
+		keys = append(keys, fmt.Sprintf("r=%d", rows))
+	}
+	return keys
+}
+
+// cellsOption parses a length given in cells.
+func cellsOption(v string) (int, bool) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// kittyChunker splits a base64 payload into kitty graphics commands.
+// The control keys are only sent with the first chunk.
+type kittyChunker struct {
+	w       io.Writer
+	control string
+	mux     Multiplexer
+	buf     []byte
+	sent    bool
+}
+
+func (c *kittyChunker) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) > kittyChunkSize {
+		if err := c.emit(c.buf[:kittyChunkSize], true); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[kittyChunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close sends the last chunk.
+func (c *kittyChunker) Close() error { return c.emit(c.buf, false) }
+
+// abort ends a transmission that could not be completed with an empty last
+// chunk, which kitty fails to decode, if any chunk was sent.
+func (c *kittyChunker) abort() error {
+	if !c.sent {
+		return nil
+	}
+	return c.emit(nil, false)
+}
+
+func (c *kittyChunker) emit(data []byte, more bool) error {
+	keys := "m=0"
+	if more {
+		keys = "m=1"
+	}
+	if !c.sent {
+		keys = c.control + "," + keys
+		c.sent = true
+	}
+	seq := fmt.Sprintf("\x1b_G%s;%s\x1b\\", keys, data)
+	_, err := io.WriteString(c.w, c.mux.Passthrough(seq))
+	return err
+}
@@ -0,0 +1,168 @@
+package imgcat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			m.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x * y), 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		t.Fatalf("could not encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestKittyEncode(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+	defer func(old string) { check(t, os.Setenv("COLUMNS", old)) }(os.Getenv("COLUMNS"))
+	defer func(old string) { check(t, os.Setenv("LINES", old)) }(os.Getenv("LINES"))
+	check(t, os.Setenv("COLUMNS", "80"))
+	check(t, os.Setenv("LINES", "24"))
+
+	img := testPNG(t, 16, 16)
+	data := base64.StdEncoding.EncodeToString(img)
+
+	tc := []struct {
+		name    string
+		options []Option
+		keys    string
+	}{
+		{"no options", nil, "a=T,f=100,q=2,i=1,p=1,m=0"},
+		{"width in cells", []Option{Width(Cells(10))}, "a=T,f=100,q=2,i=1,p=1,c=10,m=0"},
+		{"height in cells", []Option{Height(Cells(5))}, "a=T,f=100,q=2,i=1,p=1,r=5,m=0"},
+		{"width in pixels", []Option{Width(Pixels(20)), CellSize(8, 16)}, "a=T,f=100,q=2,i=1,p=1,c=3,m=0"},
+		{"width in percent", []Option{Width(Percent(100))}, "a=T,f=100,q=2,i=1,p=1,c=80,m=0"},
+		{"height in percent", []Option{Height(Percent(50))}, "a=T,f=100,q=2,i=1,p=1,r=12,m=0"},
+		{"auto width", []Option{Width(Auto())}, "a=T,f=100,q=2,i=1,p=1,m=0"},
+		{"aspect ratio keeps width", []Option{Width(Cells(2)), Height(Cells(10))}, "a=T,f=100,q=2,i=1,p=1,c=2,m=0"},
+		{"aspect ratio keeps height", []Option{Width(Cells(10)), Height(Cells(1))}, "a=T,f=100,q=2,i=1,p=1,r=1,m=0"},
+		{"stretch", []Option{Width(Cells(10)), Height(Cells(1)), PreserveAspectRatio(false)}, "a=T,f=100,q=2,i=1,p=1,c=10,r=1,m=0"},
+		{"name and inline are ignored", []Option{Name("foo"), Inline(true)}, "a=T,f=100,q=2,i=1,p=1,m=0"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			enc, err := NewProtocolEncoder(&buf, Kitty, tt.options...)
+			if err != nil {
+				t.Fatalf("could not create encoder: %v", err)
+			}
+			if err := enc.Encode(bytes.NewReader(img)); err != nil {
+				t.Fatalf("could not encode: %v", err)
+			}
+			want := "\x1b_G" + tt.keys + ";" + data + "\x1b\\\n"
+			if got := buf.String(); got != want {
+				t.Fatalf("expected output %q; got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestKittyChunks(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 128, 128)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	for id := 1; id <= 2; id++ {
+		buf.Reset()
+		if err := enc.Encode(bytes.NewReader(img)); err != nil {
+			t.Fatalf("could not encode: %v", err)
+		}
+		cmds := regexp.MustCompile("\x1b_G([^;]*);([^\x1b]*)\x1b\\\\").FindAllStringSubmatch(buf.String(), -1)
+		if len(cmds) < 2 {
+			t.Fatalf("expected several chunks, got %d", len(cmds))
+		}
+		var payload string
+		for i, cmd := range cmds {
+			keys, data := cmd[1], cmd[2]
+			switch {
+			case i == 0 && !strings.HasPrefix(keys, fmt.Sprintf("a=T,f=100,q=2,i=%d,p=1,", id)):
+				t.Errorf("bad keys in first chunk: %q", keys)
+			case i > 0 && i < len(cmds)-1 && keys != "m=1":
+				t.Errorf("bad keys in chunk %d: %q", i, keys)
+			case i == len(cmds)-1 && keys != "m=0":
+				t.Errorf("bad keys in last chunk: %q", keys)
+			}
+			if i < len(cmds)-1 && len(data) != kittyChunkSize {
+				t.Errorf("chunk %d has size %d", i, len(data))
+			}
+			payload += data
+		}
+		if want := base64.StdEncoding.EncodeToString(img); payload != want {
+			t.Errorf("payload does not match the image")
+		}
+	}
+}
+
+func TestKittyTmux(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "true"))
+
+	img := testPNG(t, 1, 1)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(bytes.NewReader(img)); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	want := "\x1bPtmux;\x1b\x1b_Ga=T,f=100,q=2,i=1,p=1,m=0;" + base64.StdEncoding.EncodeToString(img) + "\x1b\x1b\\\x1b\\\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("expected output %q; got %q", want, got)
+	}
+}
+
+func TestKittyConvertsToPNG(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	m := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var in bytes.Buffer
+	if err := gif.Encode(&in, m, nil); err != nil {
+		t.Fatalf("could not encode gif: %v", err)
+	}
+
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(&in); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	out := strings.TrimSuffix(strings.TrimPrefix(buf.String(), "\x1b_Ga=T,f=100,q=2,i=1,p=1,m=0;"), "\x1b\\\n")
+	b, err := base64.StdEncoding.DecodeString(out)
+	if err != nil {
+		t.Fatalf("could not decode payload: %v", err)
+	}
+	if !bytes.HasPrefix(b, pngMagic) {
+		t.Fatalf("payload is not a png")
+	}
+
+	if err := enc.Encode(strings.NewReader("not an image")); err == nil {
+		t.Fatalf("expected error encoding garbage")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// ChunkSize causes the iTerm2 protocol to send the base64 encoded file in
+// parts of at most n bytes, using the MultipartFile, FilePart, and FileEnd
+// sequences of iTerm2 3.5, so large images are not truncated by tmux or
+// ssh. In multiplexers every part is passed through on its own.
+// Defaults to sending the whole file in a single sequence.
+func ChunkSize(n int) Option {
+	return Option{"chunkSize", fmt.Sprint(n), func(o *Options) { o.ChunkSize = n }}
+}
+
+// encodeMultipart sends the file in parts of the given size, announcing it
+// with the given arguments.
+func (enc *Encoder) encodeMultipart(args string, r io.Reader, size int) error {
+	mux := enc.multiplexer()
+	if err := writeOSC(enc.out, "\x1b]1337;MultipartFile="+args+"\a", mux); err != nil {
+		return err
+	}
+	cw := &partChunker{w: enc.out, size: size, mux: mux}
+	b64 := base64.NewEncoder(base64.StdEncoding, cw)
+	_, err := io.Copy(b64, r)
+	if err == nil {
+		err = b64.Close()
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	// End the file even if it could not be read, so the terminal stops
+	// waiting for more parts.
+	if eerr := writeOSC(enc.out, "\x1b]1337;FileEnd\a", mux); err == nil {
+		err = eerr
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(enc.out, "\n")
+	return err
+}
+
+// writeOSC writes the escape sequence, passed through the multiplexer.
+func writeOSC(w io.Writer, seq string, mux Multiplexer) error {
+	_, err := io.WriteString(w, mux.Passthrough(seq))
+	return err
+}
+
+// partChunker splits a base64 payload into iTerm2 FilePart sequences.
+type partChunker struct {
+	w    io.Writer
+	size int
+	mux  Multiplexer
+	buf  []byte
+}
+
+func (c *partChunker) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for len(c.buf) >= c.size {
+		if err := c.emit(c.buf[:c.size]); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[c.size:]
+	}
+	return len(p), nil
+}
+
+// Close sends the last part, if any.
+func (c *partChunker) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	return c.emit(c.buf)
+}
+
+func (c *partChunker) emit(data []byte) error {
+	return writeOSC(c.w, fmt.Sprintf("\x1b]1337;FilePart=%s\a", data), c.mux)
+}
@@ -0,0 +1,86 @@
+package imgcat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMultipartGolden(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	img, err := ioutil.ReadFile(filepath.Join("testdata", "icon.png"))
+	check(t, err)
+
+	tc := []struct {
+		golden string
+		tmux   string
+	}{
+		{"icon-chunk-256.iterm2", "false"},
+		{"icon-chunk-256-tmux.iterm2", "true"},
+	}
+	for _, tt := range tc {
+		check(t, os.Setenv("TMUX_TEST", tt.tmux))
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, ITerm2, Inline(true), ChunkSize(256))
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.Encode(bytes.NewReader(img)); err != nil {
+			t.Fatalf("%s: could not encode: %v", tt.golden, err)
+		}
+		golden(t, tt.golden, buf.Bytes())
+	}
+}
+
+func TestMultipartParts(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 64, 64)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Name("a.png"), ChunkSize(100))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(bytes.NewReader(img)); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+
+	out := buf.String()
+	start := "\x1b]1337;MultipartFile=name=YS5wbmc=\a"
+	end := "\x1b]1337;FileEnd\a\n"
+	if !strings.HasPrefix(out, start) || !strings.HasSuffix(out, end) {
+		t.Fatalf("expected multipart file between %q and %q; got %q", start, end, out)
+	}
+	var payload string
+	parts := regexp.MustCompile("\x1b]1337;FilePart=([^\a]*)\a").FindAllStringSubmatch(out, -1)
+	for i, part := range parts {
+		if len(part[1]) > 100 || (len(part[1]) < 100 && i < len(parts)-1) {
+			t.Errorf("part %d has %d bytes", i, len(part[1]))
+		}
+		payload += part[1]
+	}
+	if want := base64.StdEncoding.EncodeToString(img); payload != want {
+		t.Errorf("expected parts to add up to the file")
+	}
+	if strings.Contains(out, "chunkSize") {
+		t.Errorf("chunk size sent to the terminal: %q", out)
+	}
+}
+
+func TestMultipartInvalidChunkSize(t *testing.T) {
+	for _, n := range []int{-1, -100} {
+		enc, err := NewProtocolEncoder(&bytes.Buffer{}, ITerm2, ChunkSize(n))
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.Encode(strings.NewReader("data")); err == nil {
+			t.Errorf("expected error with chunk size %d", n)
+		}
+	}
+}
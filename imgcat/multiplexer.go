@@ -0,0 +1,218 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strings"
+)
+
+// Multiplexed sets the multiplexer the output of the encoder goes through,
+// for the escape sequences to be wrapped so it passes them through to the
+// terminal. Defaults to the one returned by DetectMultiplexer.
+func Multiplexed(m Multiplexer) Option {
+	value := string(m)
+	if m == NoMultiplexer {
+		value = "none"
+	}
+	return Option{"multiplexer", value, func(o *Options) { o.Multiplexer = &m }}
+}
+
+// DetectMultiplexer returns the multiplexer the program is running in, as
+// described by its environment variables. Nested multiplexers can't be
+// detected, use the Multiplexed option for them.
+func DetectMultiplexer() Multiplexer {
+	// for testing, set TMUX_TEST true/false
+	switch os.Getenv("TMUX_TEST") {
+	case "false":
+		return NoMultiplexer
+	case "true":
+		return Tmux
+	}
+	return Detect().Multiplexer
+}
+
+// Multiplexer returns the multiplexer the output of the encoder goes
+// through.
+func (enc *Encoder) Multiplexer() Multiplexer { return enc.multiplexer() }
+
+func (enc *Encoder) multiplexer() Multiplexer {
+	if m := enc.opts().Multiplexer; m != nil {
+		return *m
+	}
+	return DetectMultiplexer()
+}
+
+// Passthrough wraps an escape sequence so the multiplexer the program is
+// running in, if any, forwards it to the terminal.
+func Passthrough(seq string) string { return DetectMultiplexer().Passthrough(seq) }
+
+// Passthrough wraps an escape sequence so the multiplexer forwards it to
+// the terminal.
+//
+// tmux forwards the sequences wrapped in a device control string starting
+// with "tmux;", with their escape characters doubled, wrapping them again
+// for each level of nesting. GNU screen forwards the contents of device
+// control strings of up to 768 bytes, so longer sequences are split.
+func (m Multiplexer) Passthrough(seq string) string {
+	if m == Screen {
+		return screenPassthrough(seq)
+	}
+	head, tail := m.wrap(seq, "")
+	return head + tail
+}
+
+// wrap wraps for tmux a sequence made of the given head and tail, with
+// printable characters in between, which are left untouched.
+func (m Multiplexer) wrap(head, tail string) (string, string) {
+	switch m {
+	case Tmux:
+		return tmuxWrap(head, tail)
+	case NestedTmux:
+		return tmuxWrap(tmuxWrap(head, tail))
+	}
+	return head, tail
+}
+
+func tmuxWrap(head, tail string) (string, string) {
+	double := func(s string) string { return strings.Replace(s, "\x1b", "\x1b\x1b", -1) }
+	return "\x1bPtmux;" + double(head), double(tail) + "\x1b\\"
+}
+
+// screenChunkSize is the maximum length of the strings GNU screen passes
+// through.
+const screenChunkSize = 768
+
+// screenPassthrough wraps the sequence in device control strings that GNU
+// screen forwards, one per chunk. The string terminators in the sequence
+// would end them early, so chunks are split after their escape character,
+// which screen keeps as it's followed by another escape character.
+func screenPassthrough(seq string) string {
+	var b strings.Builder
+	for len(seq) > 0 {
+		n := min(len(seq), screenChunkSize)
+		if i := strings.Index(seq[:n], "\x1b\\"); i >= 0 {
+			n = i + 1
+		}
+		b.WriteString("\x1bP" + seq[:n] + "\x1b\\")
+		seq = seq[n:]
+	}
+	return b.String()
+}
+
+// stream returns a writer for a sequence too long to be held in memory,
+// made of the given head, what's written to the writer, and the tail,
+// which is written when closing it. What's written must be printable
+// characters, such as base64 data. It's passed through the multiplexer as
+// Passthrough does.
+func (m Multiplexer) stream(w io.Writer, head, tail string) (io.WriteCloser, error) {
+	if m == Screen {
+		_, err := io.WriteString(w, screenPassthrough(head))
+		return &screenWriter{w: w, tail: tail}, err
+	}
+	head, tail = m.wrap(head, tail)
+	_, err := io.WriteString(w, head)
+	return &tailWriter{Writer: w, tail: tail}, err
+}
+
+// tailWriter writes the tail of a sequence when closed.
+type tailWriter struct {
+	io.Writer
+	tail string
+}
+
+func (t *tailWriter) Close() error {
+	_, err := io.WriteString(t.Writer, t.tail)
+	return err
+}
+
+// screenWriter passes what's written through GNU screen in chunks, and
+// the tail of the sequence when closed.
+type screenWriter struct {
+	w    io.Writer
+	tail string
+	buf  []byte
+}
+
+func (s *screenWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= screenChunkSize {
+		if _, err := io.WriteString(s.w, screenPassthrough(string(s.buf[:screenChunkSize]))); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[screenChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (s *screenWriter) Close() error {
+	_, err := io.WriteString(s.w, screenPassthrough(string(s.buf)+s.tail))
+	return err
+}
+
+// multiplexed reports whether images are drawn through a multiplexer that
+// doesn't know the size of the images
+// it passes through to the terminal, and rows must be reserved for them.
+// Text drawn with Blocks is seen by the multiplexer, and iTerm2 only
+// draws images inline.
+func (enc *Encoder) multiplexed() bool {
+	if enc.reserved || enc.multiplexer() == NoMultiplexer {
+		return false
+	}
+	return enc.protocol != Blocks && enc.displayed()
+}
+
+// reserveRows draws an image with the given bounds in a multiplexer. The
+// multiplexer keeps the cursor where the image starts, so the prompt and
+// the following output would be written over it. Instead, the rows used
+// by the image are reserved first, scrolling the screen if needed, and
+// the cursor is moved below the image once it's drawn, as the terminal
+// does. The rows are reserved with index (ESC D) rather than new lines,
+// which keeps the column of the cursor for images that don't start at
+// the left of the screen. These are plain cursor movements, that every
+// level of nested multiplexers handles.
+func (enc *Encoder) reserveRows(b image.Rectangle, draw func(enc *Encoder) error) error {
+	call := *enc
+	call.reserved = true
+	rows := enc.rows(b)
+	if rows <= 1 {
+		// The new line after the image is enough.
+		return draw(&call)
+	}
+	if _, err := fmt.Fprintf(enc.out, "%s\x1b[%dA", strings.Repeat("\x1bD", rows), rows); err != nil {
+		return err
+	}
+	if err := draw(&call); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(enc.out, "\x1b[%dB", rows-1)
+	return err
+}
+
+// peekConfig decodes the size of the image read from r, and returns a
+// reader with all its data. The size accounts for the EXIF orientation
+// of JPEG files, which the terminals honor.
+func peekConfig(r io.Reader) (image.Config, io.Reader, error) {
+	head := new(bytes.Buffer)
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, head))
+	data := io.MultiReader(bytes.NewReader(head.Bytes()), r)
+	if err == nil && jpegOrientation(head.Bytes()).swapsAxes() {
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+	return cfg, data, err
+}
@@ -0,0 +1,262 @@
+package imgcat
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPassthrough(t *testing.T) {
+	long := strings.Repeat("A", 2000)
+	tc := []struct {
+		mux  Multiplexer
+		seq  string
+		want string
+	}{
+		{NoMultiplexer, "\x1b]9;hi\a", "\x1b]9;hi\a"},
+		{Tmux, "\x1b]9;hi\a", "\x1bPtmux;\x1b\x1b]9;hi\a\x1b\\"},
+		{Tmux, "\x1b_Ga=d\x1b\\", "\x1bPtmux;\x1b\x1b_Ga=d\x1b\x1b\\\x1b\\"},
+		{NestedTmux, "\x1b]9;hi\a", "\x1bPtmux;\x1b\x1bPtmux;\x1b\x1b\x1b\x1b]9;hi\a\x1b\x1b\\\x1b\\"},
+		{Screen, "\x1b]9;hi\a", "\x1bP\x1b]9;hi\a\x1b\\"},
+		// The string terminator is split, so screen doesn't end the string.
+		{Screen, "\x1b_Ga=d\x1b\\", "\x1bP\x1b_Ga=d\x1b\x1b\\\x1bP\\\x1b\\"},
+		{Screen, long, "\x1bP" + long[:768] + "\x1b\\\x1bP" + long[768:1536] + "\x1b\\\x1bP" + long[1536:] + "\x1b\\"},
+	}
+	for _, tt := range tc {
+		if got := tt.mux.Passthrough(tt.seq); got != tt.want {
+			t.Errorf("%q passed through %q: expected %q; got %q", tt.seq, tt.mux, tt.want, got)
+		}
+	}
+}
+
+// screenUnwrap returns the sequences passed through screen, failing if
+// any string is too long.
+func screenUnwrap(t *testing.T, s string) string {
+	var seq string
+	for s != "" {
+		if !strings.HasPrefix(s, "\x1bP") {
+			t.Fatalf("expected a device control string, got %q", s)
+		}
+		end := strings.Index(s, "\x1b\\")
+		if end < 0 {
+			t.Fatalf("unterminated device control string %q", s)
+		}
+		if n := end - len("\x1bP"); n > screenChunkSize {
+			t.Errorf("passed %d bytes in a string; want at most %d", n, screenChunkSize)
+		}
+		seq += s[len("\x1bP"):end]
+		s = s[end+len("\x1b\\"):]
+	}
+	return seq
+}
+
+func TestScreenStream(t *testing.T) {
+	head, tail := "\x1b]1337;File=inline=1:", "\a"
+	data := strings.Repeat("QUJD", 500)
+	var buf bytes.Buffer
+	sw, err := Screen.stream(&buf, head, tail)
+	check(t, err)
+	for i := 0; i < len(data); i += 300 {
+		_, err := io.WriteString(sw, data[i:min(i+300, len(data))])
+		check(t, err)
+	}
+	check(t, sw.Close())
+	if got, want := screenUnwrap(t, buf.String()), head+data+tail; got != want {
+		t.Errorf("expected %q passed through screen; got %q", want, got)
+	}
+}
+
+// TestMultiplexerGolden checks the sequences written by every protocol
+// through each multiplexer.
+func TestMultiplexerGolden(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 24, 24)
+	m, _, err := DecodeImage(bytes.NewReader(img))
+	check(t, err)
+	for _, mux := range []Multiplexer{NoMultiplexer, Tmux, NestedTmux, Screen} {
+		var buf bytes.Buffer
+		for _, p := range []Protocol{ITerm2, Kitty, Sixel} {
+			// A single row, so no rows are reserved.
+			options := []Option{Multiplexed(mux), Inline(true), Height(Cells(1)), CellSize(8, 16)}
+			enc, err := NewProtocolEncoder(&buf, p, options...)
+			check(t, err)
+			fmt.Fprintf(&buf, "%v:\n", p)
+			check(t, enc.Encode(bytes.NewReader(img)))
+			if p == ITerm2 {
+				fmt.Fprintf(&buf, "%v multipart:\n", p)
+				check(t, enc.Encode(bytes.NewReader(img), ChunkSize(256)))
+			}
+			fmt.Fprintf(&buf, "%v image:\n", p)
+			check(t, enc.EncodeImage(m))
+		}
+		name := string(mux)
+		if mux == NoMultiplexer {
+			name = "none"
+		}
+		golden(t, "passthrough-"+name+".txt", buf.Bytes())
+	}
+}
+
+func TestDecodeNestedTmux(t *testing.T) {
+	img := testPNG(t, 16, 8)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Multiplexed(NestedTmux), Name("cat.png"))
+	check(t, err)
+	check(t, enc.Encode(bytes.NewReader(img)))
+
+	f, err := NewDecoder(&buf, ioutil.Discard).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name != "cat.png" || !bytes.Equal(f.Data, img) {
+		t.Errorf("decoded %s with %d bytes; want cat.png with %d bytes", f.Name, len(f.Data), len(img))
+	}
+}
+
+func TestDecodeScreen(t *testing.T) {
+	// Long files are passed through in many chunks, and the string
+	// terminator of a short one is split across two chunks.
+	img := testPNG(t, 64, 64)
+	short := "\x1b]1337;File=name=Yi5wbmc=:Yg==\x1b\\"
+	in := Screen.Passthrough(short) + "$ " + screenPassthrough("\x1bPq#0~\x1b\\")
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Multiplexed(Screen))
+	check(t, err)
+	check(t, enc.Encode(bytes.NewReader(img)))
+	if n := strings.Count(buf.String(), "\x1bP"); n < 3 {
+		t.Fatalf("expected the file in many chunks; got %d", n)
+	}
+	in += buf.String()
+
+	var text bytes.Buffer
+	dec := NewDecoder(strings.NewReader(in), &text)
+	f, err := dec.Next()
+	check(t, err)
+	if f == nil || f.Name != "b.png" || string(f.Data) != "b" {
+		t.Errorf("decoded %+v; want b.png", f)
+	}
+	f, err = dec.Next()
+	check(t, err)
+	if f == nil || !bytes.Equal(f.Data, img) {
+		t.Errorf("the long file was not decoded")
+	}
+	// Other sequences are kept as they are.
+	if got, want := text.String(), "$ "+screenPassthrough("\x1bPq#0~\x1b\\"); got != want {
+		t.Errorf("expected %q as text; got %q", want, got)
+	}
+}
+
+func TestDetectMultiplexer(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	defer func(d func() Capabilities) { detect = d }(detect)
+	detect = func() Capabilities { return Capabilities{Multiplexer: Screen} }
+
+	tc := map[string]Multiplexer{"true": Tmux, "false": NoMultiplexer, "": Screen}
+	for env, want := range tc {
+		check(t, os.Setenv("TMUX_TEST", env))
+		if got := DetectMultiplexer(); got != want {
+			t.Errorf("with TMUX_TEST=%q detected %q; want %q", env, got, want)
+		}
+		enc, err := NewProtocolEncoder(ioutil.Discard, Kitty)
+		check(t, err)
+		if got := enc.Multiplexer(); got != want {
+			t.Errorf("with TMUX_TEST=%q the encoder uses %q; want %q", env, got, want)
+		}
+	}
+	check(t, os.Setenv("TMUX_TEST", ""))
+	if IsTmux() {
+		t.Errorf("screen should not be detected as tmux")
+	}
+
+	enc, err := NewProtocolEncoder(ioutil.Discard, Kitty, Multiplexed(NestedTmux))
+	check(t, err)
+	if got := enc.Multiplexer(); got != NestedTmux {
+		t.Errorf("the encoder uses %q; want %q", got, NestedTmux)
+	}
+	if _, err := NewOptions(Multiplexed("byobu")); err == nil {
+		t.Errorf("expected error with an unknown multiplexer")
+	}
+	if got := Multiplexed(NoMultiplexer).String(); got != "multiplexer=none" {
+		t.Errorf("expected multiplexer=none; got %s", got)
+	}
+}
+
+func TestReserveRows(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+
+	tall, short := uniform(8, 64, red), uniform(8, 16, red)
+	tc := []struct {
+		name     string
+		tmux     string
+		protocol Protocol
+		m        image.Image
+		options  []Option
+		reserved bool
+	}{
+		{"kitty", "true", Kitty, tall, nil, true},
+		{"sixel", "true", Sixel, tall, nil, true},
+		{"iTerm2", "true", ITerm2, tall, []Option{Inline(true)}, true},
+		{"iTerm2 download", "true", ITerm2, tall, nil, false},
+		{"blocks", "true", Blocks, tall, nil, false},
+		{"one row", "true", Kitty, short, nil, false},
+		{"no tmux", "false", Kitty, tall, nil, false},
+	}
+	for _, tt := range tc {
+		check(t, os.Setenv("TMUX_TEST", tt.tmux))
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, tt.protocol, append(tt.options, CellSize(8, 16))...)
+		check(t, err)
+		check(t, enc.EncodeImage(tt.m))
+
+		got := buf.String()
+		reserved := strings.HasPrefix(got, "\x1bD\x1bD\x1bD\x1bD\x1b[4A") && strings.HasSuffix(got, "\n\x1b[3B")
+		if reserved != tt.reserved {
+			t.Errorf("%s: reserved rows %v; want %v, got %q", tt.name, reserved, tt.reserved, got)
+		}
+		if !tt.reserved && strings.Contains(got, "\x1b[4A") {
+			t.Errorf("%s: expected no cursor movements, got %q", tt.name, got)
+		}
+	}
+}
+
+func TestReserveRowsEncode(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "true"))
+
+	img := testPNG(t, 16, 40)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Inline(true), CellSize(8, 16))
+	check(t, err)
+	check(t, enc.Encode(bytes.NewReader(img)))
+
+	var text bytes.Buffer
+	dec := NewDecoder(&buf, &text)
+	f, err := dec.Next()
+	check(t, err)
+	if !bytes.Equal(f.Data, img) {
+		t.Errorf("the image was not sent untouched")
+	}
+	if _, err := dec.Next(); err != io.EOF {
+		t.Errorf("expected end of stream; got %v", err)
+	}
+	if got, want := text.String(), "\x1bD\x1bD\x1bD\x1b[3A\n\x1b[2B"; got != want {
+		t.Errorf("expected %q around the image; got %q", want, got)
+	}
+
+	// Files that are not images are sent as they are.
+	data := []byte("not an image")
+	buf.Reset()
+	check(t, enc.Encode(bytes.NewReader(data)))
+	f, err = NewDecoder(&buf, ioutil.Discard).Next()
+	check(t, err)
+	if !bytes.Equal(f.Data, data) {
+		t.Errorf("sent %q; want %q", f.Data, data)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// An Option modifies how an image is displayed.
+type Option struct {
+	key, value string
+	set        func(*Options)
+}
+
+// String returns the option as key=value, as iTerm2 would receive it.
+func (o Option) String() string { return o.key + "=" + o.value }
+
+// Options are the settings of an Encoder. Fields left to their zero value,
+// or nil, use the default of the terminal or of the protocol.
+type Options struct {
+	// Name of the file sent to iTerm2.
+	Name string
+	// Size of the file in bytes, used by the iTerm2 progress indicator.
+	Size int
+	// Width and Height to render the image at.
+	Width, Height Length
+	// PreserveAspectRatio defaults to true.
+	PreserveAspectRatio *bool
+	// Inline displays the image instead of downloading it in iTerm2.
+	Inline *bool
+
+	// Colors, Quantizer, and Dither are used by Sixel.
+	Colors    int
+	Quantizer Quantizer
+	// Dither defaults to true.
+	Dither *bool
+
+	// Glyphs and Depth are used by Blocks.
+	Glyphs Glyphs
+	Depth  ColorDepth
+
+	// Resample, MaxBytes, and the cell size control local resizing.
+	Resample              Filter
+	MaxBytes              int
+	CellWidth, CellHeight int
+
+	// ChunkSize splits iTerm2 transfers in multiple parts.
+	ChunkSize int
+	// KeepMetadata sends files to iTerm2 and kitty untouched.
+	KeepMetadata bool
+	// Background is composited under transparent images by Sixel and Blocks.
+	Background color.Color
+	// Multiplexer the output goes through, detected when nil.
+	Multiplexer *Multiplexer
+}
+
+// NewOptions returns the options set by the given list, where later
+// options override earlier ones. It fails if they are not valid.
+func NewOptions(opts ...Option) (Options, error) {
+	o := Options{}.With(opts...)
+	return o, o.Validate()
+}
+
+// With returns a copy of o with the given options overriding its fields.
+func (o Options) With(opts ...Option) Options {
+	for _, opt := range opts {
+		if opt.set != nil {
+			opt.set(&o)
+		}
+	}
+	return o
+}
+
+// Validate checks that the options can be sent to a terminal.
+func (o Options) Validate() error {
+	if o.Size < 0 {
+		return fmt.Errorf("invalid size %d", o.Size)
+	}
+	if err := o.Width.validate(); err != nil {
+		return fmt.Errorf("invalid width: %v", err)
+	}
+	if err := o.Height.validate(); err != nil {
+		return fmt.Errorf("invalid height: %v", err)
+	}
+	if o.Colors != 0 && (o.Colors < 2 || o.Colors > maxSixelColors) {
+		return fmt.Errorf("palette size should be between 2 and %d, got %d", maxSixelColors, o.Colors)
+	}
+	switch o.Quantizer {
+	case "", MedianCut, Octree:
+	default:
+		return fmt.Errorf("unknown quantizer %q", o.Quantizer)
+	}
+	switch o.Glyphs {
+	case "", HalfBlocks, Quadrants, Braille, ASCII, ASCIIEdges:
+	default:
+		return fmt.Errorf("unknown glyphs %q", o.Glyphs)
+	}
+	switch o.Depth {
+	case 0, TrueColor, Colors256, Colors16, Monochrome:
+	default:
+		return fmt.Errorf("unsupported color depth %d", o.Depth)
+	}
+	if _, ok := kernels[o.Resample]; o.Resample != "" && !ok {
+		return fmt.Errorf("unknown filter %q", o.Resample)
+	}
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("invalid maximum number of bytes %d", o.MaxBytes)
+	}
+	if o.CellWidth < 0 || o.CellHeight < 0 || (o.CellWidth == 0) != (o.CellHeight == 0) {
+		return fmt.Errorf("invalid cell size %dx%d", o.CellWidth, o.CellHeight)
+	}
+	if o.Multiplexer != nil {
+		switch *o.Multiplexer {
+		case NoMultiplexer, Tmux, NestedTmux, Screen:
+		default:
+			return fmt.Errorf("unknown multiplexer %q", *o.Multiplexer)
+		}
+	}
+	if o.ChunkSize < 0 {
+		return fmt.Errorf("invalid chunk size %d", o.ChunkSize)
+	}
+	return nil
+}
+
+// ParseLength parses a length as written by Cells, Pixels, Percent, or
+// Auto, such as "10", "10px", "10%", or "auto".
+func ParseLength(s string) (Length, error) {
+	l := Length(s)
+	if s == "" {
+		return "", fmt.Errorf("empty length")
+	}
+	if err := l.validate(); err != nil {
+		return "", err
+	}
+	return l, nil
+}
+
+// validate checks that the length is empty, auto, or a positive number
+// of cells, pixels, or percent.
+func (l Length) validate() error {
+	if l == "" || l == Auto() {
+		return nil
+	}
+	n, _, err := parseLength(l)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("length %q should be positive", l)
+	}
+	return nil
+}
+
+func (o Options) preserveAspectRatio() bool {
+	return o.PreserveAspectRatio == nil || *o.PreserveAspectRatio
+}
+
+func (o Options) dither() bool { return o.Dither == nil || *o.Dither }
+
+// iTerm2Args returns the arguments of an iTerm2 file transfer, only for
+// the options that were set.
+func (o Options) iTerm2Args() string {
+	var args []string
+	if o.Inline != nil {
+		args = append(args, fmt.Sprintf("inline=%d", boolToInt(*o.Inline)))
+	}
+	if o.Name != "" {
+		args = append(args, "name="+base64.StdEncoding.EncodeToString([]byte(o.Name)))
+	}
+	if o.Width != "" {
+		args = append(args, fmt.Sprintf("width=%s", o.Width))
+	}
+	if o.Height != "" {
+		args = append(args, fmt.Sprintf("height=%s", o.Height))
+	}
+	if o.PreserveAspectRatio != nil {
+		args = append(args, fmt.Sprintf("preserveAspectRatio=%d", boolToInt(*o.PreserveAspectRatio)))
+	}
+	if o.Size > 0 {
+		args = append(args, fmt.Sprintf("size=%d", o.Size))
+	}
+	return strings.Join(args, ";")
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package imgcat
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNewOptions(t *testing.T) {
+	o, err := NewOptions(Width(Cells(10)), Name("a.png"), Width(Pixels(20)), PreserveAspectRatio(false))
+	if err != nil {
+		t.Fatalf("could not create options: %v", err)
+	}
+	if o.Width != Pixels(20) || o.Name != "a.png" || o.preserveAspectRatio() {
+		t.Errorf("unexpected options %+v", o)
+	}
+	if o.Height != "" || o.Inline != nil || !o.dither() {
+		t.Errorf("expected unset options to keep their defaults; got %+v", o)
+	}
+	if got, want := o.iTerm2Args(), "name=YS5wbmc=;width=20px;preserveAspectRatio=0"; got != want {
+		t.Errorf("expected iTerm2 arguments %q; got %q", want, got)
+	}
+	if got := Width(Pixels(20)).String(); got != "width=20px" {
+		t.Errorf("expected option width=20px; got %s", got)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tc := []Option{
+		Width(Pixels(-5)), Height(Cells(0)), Width("big"), Size(-1),
+		Colors(1), Quantization("foo"), GlyphSet("foo"), Depth(3),
+		Resample("foo"), MaxBytes(-1), CellSize(8, 0), ChunkSize(-1),
+	}
+	for _, o := range tc {
+		if _, err := NewOptions(o); err == nil {
+			t.Errorf("expected error with option %s", o)
+		}
+	}
+	for _, o := range []Option{Width(Auto()), Height(Percent(100)), Colors(2), CellSize(0, 0)} {
+		if _, err := NewOptions(o); err != nil {
+			t.Errorf("unexpected error with option %s: %v", o, err)
+		}
+	}
+}
+
+func TestEncodeOverrides(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Inline(true), Width(Cells(10)), Width(Cells(20)))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(strings.NewReader("a"), Name("a.png")); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	if err := enc.Encode(strings.NewReader("b"), Name("b.png"), Inline(false)); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	if err := enc.Encode(strings.NewReader("c")); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	want := "\x1b]1337;File=inline=1;name=YS5wbmc=;width=20:YQ==\a\n" +
+		"\x1b]1337;File=inline=0;name=Yi5wbmc=;width=20:Yg==\a\n" +
+		"\x1b]1337;File=inline=1;width=20:Yw==\a\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected %q; got %q", want, got)
+	}
+	if o := enc.Options(); o.Name != "" || o.Width != Cells(20) {
+		t.Errorf("encoder options modified by a call: %+v", o)
+	}
+}
+
+func TestEncodeInvalidOptions(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Width(Pixels(-5)))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(strings.NewReader("test")); err == nil {
+		t.Errorf("expected error with invalid width")
+	}
+	enc, err = NewProtocolEncoder(&buf, ITerm2)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(strings.NewReader("test"), Height(Percent(-1))); err == nil {
+		t.Errorf("expected error with invalid height")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("invalid options sent to the terminal: %q", buf.String())
+	}
+}
+
+func TestKittyOverridesKeepIDs(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 2, 2)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	check(t, enc.Encode(bytes.NewReader(img), Width(Cells(2))))
+	check(t, enc.Encode(bytes.NewReader(img)))
+	if !strings.Contains(buf.String(), "i=1,") || !strings.Contains(buf.String(), "i=2,") {
+		t.Errorf("expected images with ids 1 and 2; got %q", buf.String())
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	for _, s := range []string{"10", "10px", "10%", "auto"} {
+		if l, err := ParseLength(s); err != nil || string(l) != s {
+			t.Errorf("could not parse %q: %v, %v", s, l, err)
+		}
+	}
+	for _, s := range []string{"", "0", "-5px", "10em", "ten"} {
+		if _, err := ParseLength(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestParseProtocol(t *testing.T) {
+	for _, p := range []Protocol{ITerm2, Kitty, Sixel, Blocks} {
+		if got, err := ParseProtocol(strings.ToUpper(p.String())); err != nil || got != p {
+			t.Errorf("could not parse %v: %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseProtocol("png"); err == nil {
+		t.Errorf("expected error parsing unknown protocol")
+	}
+}
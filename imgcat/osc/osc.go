@@ -0,0 +1,236 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package osc writes the operating system command escape sequences of
+// iTerm2, and the ones of other terminals that iTerm2 also understands:
+// hyperlinks, clipboard copies, marks, badges, user variables,
+// notifications, requests for attention, the current directory, and
+// requests to upload files.
+//
+// Like imgcat, it wraps the sequences so tmux forwards them to the outer
+// terminal when running in tmux.
+//
+// See https://iterm2.com/documentation-escape-codes.html.
+package osc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+// A Sequence is an escape sequence. It's one of the types of this package.
+type Sequence interface {
+	// escape returns the sequence, or an error if its fields are not valid.
+	escape() (string, error)
+}
+
+// Write writes the sequences to w, wrapped for tmux if needed.
+// Nothing is written if any of them is not valid.
+func Write(w io.Writer, seqs ...Sequence) error {
+	var s string
+	for _, seq := range seqs {
+		e, err := Escape(seq)
+		if err != nil {
+			return err
+		}
+		s += e
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// Escape returns the sequence as Write would write it.
+func Escape(seq Sequence) (string, error) {
+	s, err := seq.escape()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := seq.(Hyperlink); ok {
+		// tmux handles hyperlinks itself, as the text is in its screen.
+		return s, nil
+	}
+	return imgcat.Passthrough(s), nil
+}
+
+// iTerm2 returns an iTerm2 proprietary sequence with the given command.
+func iTerm2(cmd string) string { return "\x1b]1337;" + cmd + "\a" }
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+// hasControl reports whether s has any control characters, which would
+// end or break a sequence.
+func hasControl(s string) bool { return strings.IndexFunc(s, unicode.IsControl) >= 0 }
+
+// A Hyperlink is text that opens a URL when clicked. It's written as the
+// text between the sequences that start and end the link, and it's never
+// wrapped for tmux, as tmux 3.1 and later handle hyperlinks themselves.
+type Hyperlink struct {
+	URL  string
+	Text string
+	// ID, if set, makes the terminal highlight together the hyperlinks
+	// with the same one, such as a link split in multiple lines.
+	ID string
+}
+
+func (h Hyperlink) escape() (string, error) {
+	if h.URL == "" {
+		return "", fmt.Errorf("empty hyperlink URL")
+	}
+	for _, r := range h.URL {
+		if r < 32 || r > 126 {
+			return "", fmt.Errorf("hyperlink URL %q should only have printable ASCII characters", h.URL)
+		}
+	}
+	if strings.ContainsAny(h.ID, ":;") || hasControl(h.ID) {
+		return "", fmt.Errorf("invalid hyperlink id %q", h.ID)
+	}
+	if hasControl(h.Text) {
+		return "", fmt.Errorf("hyperlink text %q has control characters", h.Text)
+	}
+	params := ""
+	if h.ID != "" {
+		params = "id=" + h.ID
+	}
+	return fmt.Sprintf("\x1b]8;%s;%s\x1b\\%s\x1b]8;;\x1b\\", params, h.URL, h.Text), nil
+}
+
+// A Selection is where copied text is stored.
+type Selection string
+
+const (
+	// Clipboard is the system clipboard.
+	Clipboard Selection = "c"
+	// Primary is the primary selection of X11, pasted with the middle
+	// button of the mouse.
+	Primary Selection = "p"
+)
+
+// A Copy copies text to the clipboard with OSC 52. Some terminals, such
+// as iTerm2, must be configured to allow it.
+type Copy struct {
+	Text string
+	// Selection defaults to Clipboard.
+	Selection Selection
+}
+
+func (c Copy) escape() (string, error) {
+	sel := c.Selection
+	switch sel {
+	case "":
+		sel = Clipboard
+	case Clipboard, Primary:
+	default:
+		return "", fmt.Errorf("unknown selection %q", sel)
+	}
+	return fmt.Sprintf("\x1b]52;%s;%s\a", sel, b64(c.Text)), nil
+}
+
+// A SetMark sets a mark at the current line, which can be jumped to with
+// Cmd-Shift-Up and Down in iTerm2.
+type SetMark struct{}
+
+func (SetMark) escape() (string, error) { return iTerm2("SetMark"), nil }
+
+// A Badge sets the badge of the session, the text shown in the top right
+// corner. Its format may refer to variables, as in \(user.name). An empty
+// format removes the badge.
+type Badge struct {
+	Format string
+}
+
+func (b Badge) escape() (string, error) { return iTerm2("SetBadgeFormat=" + b64(b.Format)), nil }
+
+// A UserVar sets a variable of the session, which can be used in badges
+// as \(user.Name), among others.
+type UserVar struct {
+	Name, Value string
+}
+
+func (u UserVar) escape() (string, error) {
+	if u.Name == "" || strings.ContainsAny(u.Name, "=") || hasControl(u.Name) {
+		return "", fmt.Errorf("invalid user variable name %q", u.Name)
+	}
+	return iTerm2("SetUserVar=" + u.Name + "=" + b64(u.Value)), nil
+}
+
+// A Notification posts a notification with the given message with OSC 9,
+// as done by iTerm2 and other terminals.
+type Notification struct {
+	Message string
+}
+
+func (n Notification) escape() (string, error) {
+	if hasControl(n.Message) {
+		return "", fmt.Errorf("notification %q has control characters", n.Message)
+	}
+	return "\x1b]9;" + n.Message + "\a", nil
+}
+
+// An Attention says how the application asks for the attention of the
+// user.
+type Attention string
+
+const (
+	// Bounce bounces the dock icon until the application is activated.
+	Bounce Attention = "yes"
+	// BounceOnce bounces the dock icon once.
+	BounceOnce Attention = "once"
+	// StopBouncing cancels a previous request for attention.
+	StopBouncing Attention = "no"
+	// Fireworks shows fireworks at the cursor.
+	Fireworks Attention = "fireworks"
+)
+
+// A RequestAttention asks for the attention of the user. The Attention
+// defaults to Bounce.
+type RequestAttention struct {
+	Attention Attention
+}
+
+func (r RequestAttention) escape() (string, error) {
+	a := r.Attention
+	switch a {
+	case "":
+		a = Bounce
+	case Bounce, BounceOnce, StopBouncing, Fireworks:
+	default:
+		return "", fmt.Errorf("unknown attention %q", a)
+	}
+	return iTerm2("RequestAttention=" + string(a)), nil
+}
+
+// A CurrentDir tells the terminal the current directory, which is used by
+// iTerm2 to open new sessions and for the semantic history.
+type CurrentDir struct {
+	Dir string
+}
+
+func (c CurrentDir) escape() (string, error) {
+	if c.Dir == "" || hasControl(c.Dir) {
+		return "", fmt.Errorf("invalid directory %q", c.Dir)
+	}
+	return iTerm2("CurrentDir=" + c.Dir), nil
+}
+
+// A RequestUpload asks iTerm2 to let the user pick files to upload. They're
+// sent back as input, as a line with "ok" followed by the lines of a base64
+// encoded gzipped tar file ending with an empty line, or as a line with
+// "abort" if the user cancelled. See the transfer package.
+type RequestUpload struct{}
+
+func (RequestUpload) escape() (string, error) { return iTerm2("RequestUpload=format=tgz"), nil }
@@ -0,0 +1,110 @@
+package osc
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/campoy/tools/imgcat/imgtest"
+)
+
+var sequences = []struct {
+	name string
+	seq  Sequence
+}{
+	{"hyperlink", Hyperlink{URL: "https://github.com/campoy/tools", Text: "campoy/tools"}},
+	{"hyperlink-id", Hyperlink{URL: "https://golang.org", Text: "Go", ID: "go"}},
+	{"copy", Copy{Text: "hello, world"}},
+	{"copy-primary", Copy{Text: "hello, world", Selection: Primary}},
+	{"setmark", SetMark{}},
+	{"badge", Badge{Format: `\(user.name) on \(session.hostname)`}},
+	{"badge-empty", Badge{}},
+	{"uservar", UserVar{Name: "branch", Value: "master"}},
+	{"notification", Notification{Message: "build finished"}},
+	{"attention", RequestAttention{}},
+	{"attention-fireworks", RequestAttention{Attention: Fireworks}},
+	{"currentdir", CurrentDir{Dir: "/home/gopher/src"}},
+	{"requestupload", RequestUpload{}},
+}
+
+func TestEscape(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	for _, tmux := range []bool{false, true} {
+		suffix := ".txt"
+		if tmux {
+			check(t, os.Setenv("TMUX_TEST", "true"))
+			suffix = "-tmux.txt"
+		} else {
+			check(t, os.Setenv("TMUX_TEST", "false"))
+		}
+		for _, tt := range sequences {
+			got, err := Escape(tt.seq)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			imgtest.Golden(t, filepath.Join("testdata", tt.name+suffix), []byte(got))
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	var buf bytes.Buffer
+	if err := Write(&buf, SetMark{}, Hyperlink{URL: "https://golang.org", Text: "Go"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b]1337;SetMark\a\x1b]8;;https://golang.org\x1b\\Go\x1b]8;;\x1b\\"
+	if got := buf.String(); got != want {
+		t.Errorf("wrote %q; want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, SetMark{}, UserVar{}); err == nil {
+		t.Errorf("expected error writing an invalid sequence")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("nothing should be written when a sequence is invalid, got %q", buf.String())
+	}
+
+	if err := Write(badWriter{}, SetMark{}); err == nil {
+		t.Errorf("expected error writing to a bad writer")
+	}
+}
+
+type badWriter struct{}
+
+func (badWriter) Write([]byte) (int, error) { return 0, errors.New("bad writer") }
+
+func TestInvalid(t *testing.T) {
+	tc := []Sequence{
+		Hyperlink{Text: "no URL"},
+		Hyperlink{URL: "https://golang.org/\x1b", Text: "Go"},
+		Hyperlink{URL: "https://golang.org/ü", Text: "Go"},
+		Hyperlink{URL: "https://golang.org", Text: "Go", ID: "a;b"},
+		Hyperlink{URL: "https://golang.org", Text: "Go\a"},
+		Copy{Text: "text", Selection: "x"},
+		UserVar{Value: "no name"},
+		UserVar{Name: "a=b"},
+		UserVar{Name: "a\nb"},
+		Notification{Message: "ring\a"},
+		RequestAttention{Attention: "maybe"},
+		CurrentDir{},
+		CurrentDir{Dir: "/tmp\x1b"},
+	}
+	for _, seq := range tc {
+		if s, err := Escape(seq); err == nil {
+			t.Errorf("expected error escaping %#v, got %q", seq, s)
+		}
+	}
+}
+
+func check(t *testing.T, err error) {
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// A Placement is an image drawn at a given position of the screen, which
+// can be replaced, moved, or deleted, as done by dashboards that redraw
+// the same chart.
+//
+// With kitty, images are placed with an id, and moving or deleting them
+// leaves the text under them untouched. The other protocols have no
+// notion of placed images: the image is drawn again where it's moved to,
+// and the cells it covered are overwritten with spaces.
+//
+// Placements don't scroll the screen, so images should fit in it.
+type Placement struct {
+	enc     *Encoder
+	m       image.Image
+	options []Option
+
+	// position of the top left corner and size of the image, in cells.
+	row, col   int
+	cols, rows int
+	// id of the image with kitty, zero for the other protocols.
+	id      int
+	deleted bool
+}
+
+// Escape sequences used to draw placements.
+const (
+	saveCursor    = "\x1b7"
+	restoreCursor = "\x1b8"
+)
+
+// moveCursor returns the escape sequence moving the cursor to the given
+// row and column, starting at 1.
+func moveCursor(row, col int) string { return fmt.Sprintf("\x1b[%d;%dH", row, col) }
+
+// Place draws the image with its top left corner at the given row and
+// column of the screen, starting at 1, and returns its placement. The
+// cursor is left where it was. The given options override the ones of the
+// encoder for this image only.
+//
+// The image is drawn over the whole cells it covers, which may stretch
+// it slightly.
+func (enc *Encoder) Place(m image.Image, row, col int, options ...Option) (*Placement, error) {
+	if row < 1 || col < 1 {
+		return nil, fmt.Errorf("invalid position %d,%d", row, col)
+	}
+	p := &Placement{enc: enc, row: row, col: col}
+	if err := p.draw(m, options, ""); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Position returns the row and column of the top left corner of the image.
+func (p *Placement) Position() (row, col int) { return p.row, p.col }
+
+// Size returns the number of columns and rows covered by the image.
+func (p *Placement) Size() (cols, rows int) { return p.cols, p.rows }
+
+// Replace draws the given image instead of the placed one, at the same
+// position. The given options override the ones of the encoder.
+//
+// The new image is drawn over the old one, which is only erased first if
+// the sizes differ, so redrawing doesn't flicker. The old image shows
+// through the transparent pixels of sixel images, unless a Background is
+// set.
+func (p *Placement) Replace(m image.Image, options ...Option) error {
+	if p.deleted {
+		return fmt.Errorf("the image was deleted")
+	}
+	cols, rows, err := p.enc.placedSize(m, options)
+	if err != nil {
+		return err
+	}
+	erase := ""
+	if p.id == 0 && (cols != p.cols || rows != p.rows) {
+		erase = p.erase()
+	}
+	return p.draw(m, options, erase)
+}
+
+// Move moves the image so its top left corner is at the given row and
+// column.
+func (p *Placement) Move(row, col int) error {
+	if p.deleted {
+		return fmt.Errorf("the image was deleted")
+	}
+	if row < 1 || col < 1 {
+		return fmt.Errorf("invalid position %d,%d", row, col)
+	}
+	if p.id == 0 {
+		erase := p.erase()
+		p.row, p.col = row, col
+		return p.draw(p.m, p.options, erase)
+	}
+	p.row, p.col = row, col
+	seq := p.enc.kittyCommand(fmt.Sprintf("a=p,i=%d,p=1,c=%d,r=%d,C=1,q=2", p.id, p.cols, p.rows))
+	_, err := fmt.Fprint(p.enc.out, saveCursor+moveCursor(row, col)+seq+restoreCursor)
+	return err
+}
+
+// Delete removes the image from the screen. The placement can't be used
+// after that.
+func (p *Placement) Delete() error {
+	if p.deleted {
+		return nil
+	}
+	p.deleted = true
+	seq := saveCursor + p.erase() + restoreCursor
+	if p.id != 0 {
+		seq = p.enc.kittyDelete(p.id)
+	}
+	_, err := fmt.Fprint(p.enc.out, seq)
+	return err
+}
+
+// draw draws the image at the position of the placement, after the given
+// escape sequences, and records it.
+func (p *Placement) draw(m image.Image, options []Option, before string) error {
+	cols, rows, err := p.enc.placedSize(m, options)
+	if err != nil {
+		return err
+	}
+	call, err := p.enc.with(options)
+	if err != nil {
+		return err
+	}
+	cw, ch := call.cellPixels()
+	// The size in cells is given so the image covers them exactly, also
+	// when drawn to a buffer that doesn't know the size of the terminal.
+	options = append(options[:len(options):len(options)], Inline(true), CellSize(cw, ch), Width(Cells(cols)), Height(Cells(rows)))
+	enc, err := p.enc.with(options)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(saveCursor + before)
+	var id int
+	switch enc.protocol {
+	case Kitty:
+		id = enc.nextKittyID()
+		buf.WriteString(moveCursor(p.row, p.col))
+		call := *enc
+		call.out = buf
+		if err := call.placeKitty(m, id, cols, rows); err != nil {
+			return err
+		}
+		if p.id != 0 {
+			// The old image is deleted once the new one is drawn.
+			buf.WriteString(enc.kittyDelete(p.id))
+		}
+	default:
+		img := new(bytes.Buffer)
+		call := *enc
+		// Placements don't move the cursor, nor scroll in multiplexers.
+		call.out, call.reserved = img, true
+		if err := call.EncodeImage(m); err != nil {
+			return err
+		}
+		text := strings.TrimSuffix(img.String(), "\n")
+		if enc.protocol != Blocks {
+			buf.WriteString(moveCursor(p.row, p.col) + text)
+			break
+		}
+		// Text is drawn line by line, as new lines go to the first column.
+		for i, line := range strings.Split(text, "\n") {
+			buf.WriteString(moveCursor(p.row+i, p.col) + line)
+		}
+	}
+	buf.WriteString(restoreCursor)
+
+	if _, err := p.enc.out.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	p.m, p.options = m, options
+	p.cols, p.rows, p.id = cols, rows, id
+	return nil
+}
+
+// placedSize returns the number of columns and rows covered by an image
+// drawn with the given options.
+func (enc *Encoder) placedSize(m image.Image, options []Option) (cols, rows int, err error) {
+	enc, err = enc.with(options)
+	if err != nil {
+		return 0, 0, err
+	}
+	b := m.Bounds()
+	if b.Empty() {
+		return 0, 0, fmt.Errorf("cannot place an empty image")
+	}
+	if enc.protocol == Blocks {
+		cols, rows = enc.cellSize(b)
+		return cols, rows, nil
+	}
+	w, h := enc.pixelSize(b)
+	cw, ch := enc.cellPixels()
+	return (w + cw - 1) / cw, (h + ch - 1) / ch, nil
+}
+
+// erase returns the escape sequences that overwrite the cells covered by
+// the image with spaces.
+func (p *Placement) erase() string {
+	s := "\x1b[0m"
+	for i := 0; i < p.rows; i++ {
+		s += moveCursor(p.row+i, p.col) + strings.Repeat(" ", p.cols)
+	}
+	return s
+}
+
+// placeKitty sends the pixels of the image with the given id, covering the
+// given cells, without moving the cursor.
+func (enc *Encoder) placeKitty(m image.Image, id, cols, rows int) error {
+	m, data, err := enc.fit(m, encodeRGBA)
+	if err != nil {
+		return err
+	}
+	b := m.Bounds()
+	keys := []string{
+		"a=T", "f=32", fmt.Sprintf("s=%d", b.Dx()), fmt.Sprintf("v=%d", b.Dy()), "o=z",
+		"q=2", fmt.Sprintf("i=%d", id), "p=1", fmt.Sprintf("c=%d", cols), fmt.Sprintf("r=%d", rows), "C=1",
+	}
+	return enc.transmitKitty(keys, bytes.NewReader(data))
+}
+
+// kittyCommand returns a kitty graphics command without payload, wrapped
+// for the multiplexer.
+func (enc *Encoder) kittyCommand(keys string) string {
+	return enc.multiplexer().Passthrough("\x1b_G" + keys + "\x1b\\")
+}
+
+// kittyDelete returns the command deleting the image with the given id,
+// and freeing its data.
+func (enc *Encoder) kittyDelete(id int) string {
+	return enc.kittyCommand(fmt.Sprintf("a=d,d=I,i=%d,q=2", id))
+}
@@ -0,0 +1,207 @@
+package imgcat
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"strings"
+	"testing"
+)
+
+// uniform returns an image of the given size and color.
+func uniform(w, h int, c color.Color) image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return m
+}
+
+func TestPlaceBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Blocks, CellSize(1, 2))
+	check(t, err)
+
+	const (
+		redCells   = "\x1b[38;2;255;0;0m\x1b[48;2;255;0;0m"
+		greenCells = "\x1b[38;2;0;255;0m\x1b[48;2;0;255;0m"
+	)
+	var p *Placement
+	steps := []struct {
+		name string
+		do   func() error
+		want string
+	}{
+		{
+			"place",
+			func() (err error) { p, err = enc.Place(uniform(2, 4, red), 3, 5); return err },
+			"\x1b7\x1b[3;5H" + redCells + "▀▀\x1b[0m\x1b[4;5H" + redCells + "▀▀\x1b[0m\x1b8",
+		},
+		{
+			"replace",
+			func() error { return p.Replace(uniform(2, 4, green)) },
+			"\x1b7\x1b[3;5H" + greenCells + "▀▀\x1b[0m\x1b[4;5H" + greenCells + "▀▀\x1b[0m\x1b8",
+		},
+		{
+			"replace smaller",
+			func() error { return p.Replace(uniform(1, 2, red)) },
+			"\x1b7\x1b[0m\x1b[3;5H  \x1b[4;5H  \x1b[3;5H" + redCells + "▀\x1b[0m\x1b8",
+		},
+		{
+			"move",
+			func() error { return p.Move(1, 1) },
+			"\x1b7\x1b[0m\x1b[3;5H \x1b[1;1H" + redCells + "▀\x1b[0m\x1b8",
+		},
+		{
+			"delete",
+			func() error { return p.Delete() },
+			"\x1b7\x1b[0m\x1b[1;1H \x1b8",
+		},
+	}
+	for _, s := range steps {
+		buf.Reset()
+		if err := s.do(); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		if got := buf.String(); got != s.want {
+			t.Errorf("%s: expected %q; got %q", s.name, s.want, got)
+		}
+	}
+	if err := p.Replace(uniform(1, 2, red)); err == nil {
+		t.Errorf("expected error replacing a deleted image")
+	}
+}
+
+func TestPlaceKitty(t *testing.T) {
+	defer func(old string) { check(t, os.Setenv("TMUX_TEST", old)) }(os.Getenv("TMUX_TEST"))
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Kitty, CellSize(8, 16))
+	check(t, err)
+
+	p, err := enc.Place(uniform(12, 16, red), 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols, rows := p.Size(); cols != 2 || rows != 1 {
+		t.Errorf("placed image covers %dx%d cells; want 2x1", cols, rows)
+	}
+	got := buf.String()
+	if want := "\x1b7\x1b[2;3H\x1b_Ga=T,f=32,s=12,v=16,o=z,q=2,i=1,p=1,c=2,r=1,C=1,m=0;"; !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "\x1b\\\x1b8") {
+		t.Errorf("expected the image placed at 2,3 without moving the cursor, got %q", got)
+	}
+
+	buf.Reset()
+	check(t, p.Replace(uniform(12, 16, green)))
+	got = buf.String()
+	if !strings.Contains(got, ",i=2,p=1,c=2,r=1,C=1,") || !strings.HasSuffix(got, "\x1b_Ga=d,d=I,i=1,q=2\x1b\\\x1b8") {
+		t.Errorf("expected a new image drawn before deleting the old one, got %q", got)
+	}
+
+	steps := []struct {
+		name string
+		do   func() error
+		want string
+	}{
+		{"move", func() error { return p.Move(4, 1) }, "\x1b7\x1b[4;1H\x1b_Ga=p,i=2,p=1,c=2,r=1,C=1,q=2\x1b\\\x1b8"},
+		{"delete", p.Delete, "\x1b_Ga=d,d=I,i=2,q=2\x1b\\"},
+		{"delete again", p.Delete, ""},
+	}
+	for _, s := range steps {
+		buf.Reset()
+		check(t, s.do())
+		if got := buf.String(); got != s.want {
+			t.Errorf("%s: expected %q; got %q", s.name, s.want, got)
+		}
+	}
+	if row, col := p.Position(); row != 4 || col != 1 {
+		t.Errorf("image moved to %d,%d; want 4,1", row, col)
+	}
+}
+
+func TestPlaceOverwrite(t *testing.T) {
+	defer func(old string) { check(t, os.Setenv("TMUX_TEST", old)) }(os.Getenv("TMUX_TEST"))
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	tc := []struct {
+		protocol Protocol
+		image    string
+	}{
+		{ITerm2, "\x1b]1337;File=inline=1;width=2;height=1;"},
+		{Sixel, "\x1bP0;1;0q\"1;1;12;16"},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, tt.protocol, CellSize(8, 16))
+		check(t, err)
+		p, err := enc.Place(uniform(12, 16, red), 2, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); !strings.HasPrefix(got, "\x1b7\x1b[2;3H"+tt.image) || strings.Contains(got, "\n") {
+			t.Errorf("%v: expected the image drawn at 2,3 without new lines, got %q", tt.protocol, got)
+		}
+
+		buf.Reset()
+		check(t, p.Move(4, 1))
+		if got := buf.String(); !strings.HasPrefix(got, "\x1b7\x1b[0m\x1b[2;3H  \x1b[4;1H"+tt.image) {
+			t.Errorf("%v: expected the image erased and drawn at 4,1, got %q", tt.protocol, got)
+		}
+
+		buf.Reset()
+		check(t, p.Delete())
+		if got, want := buf.String(), "\x1b7\x1b[0m\x1b[4;1H  \x1b8"; got != want {
+			t.Errorf("%v: expected %q deleting the image; got %q", tt.protocol, want, got)
+		}
+	}
+}
+
+func TestPlaceCellSize(t *testing.T) {
+	defer func(old string) { check(t, os.Setenv("TMUX_TEST", old)) }(os.Getenv("TMUX_TEST"))
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	// The cell size given to Place sizes the image it draws.
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Sixel)
+	check(t, err)
+	p, err := enc.Place(uniform(200, 200, red), 1, 1, CellSize(10, 20), Width(Cells(10)))
+	check(t, err)
+	if cols, rows := p.Size(); cols != 10 || rows != 5 {
+		t.Errorf("placed in %dx%d cells; want 10x5", cols, rows)
+	}
+	if !strings.Contains(buf.String(), `"1;1;100;100`) {
+		t.Errorf("expected a 100x100 sixel image, got %q", buf.String())
+	}
+}
+
+func TestPlaceErrors(t *testing.T) {
+	enc, err := NewProtocolEncoder(new(bytes.Buffer), Blocks)
+	check(t, err)
+	if _, err := enc.Place(uniform(1, 1, red), 0, 1); err == nil {
+		t.Errorf("expected error placing an image at row 0")
+	}
+	if _, err := enc.Place(image.NewNRGBA(image.Rectangle{}), 1, 1); err == nil {
+		t.Errorf("expected error placing an empty image")
+	}
+	p, err := enc.Place(uniform(1, 1, red), 1, 1)
+	check(t, err)
+	if err := p.Move(1, -1); err == nil {
+		t.Errorf("expected error moving an image to column -1")
+	}
+}
+
+func TestPlaceTmux(t *testing.T) {
+	defer func(old string) { check(t, os.Setenv("TMUX_TEST", old)) }(os.Getenv("TMUX_TEST"))
+	check(t, os.Setenv("TMUX_TEST", "true"))
+
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Sixel, CellSize(8, 16))
+	check(t, err)
+	if _, err := enc.Place(uniform(8, 64, red), 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "\x1b7\x1b[2;3H\x1bPtmux;") || strings.Contains(got, "\n") {
+		t.Errorf("expected the image drawn at 2,3 without reserving rows, got %q", got)
+	}
+}
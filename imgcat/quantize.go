@@ -0,0 +1,331 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// A Quantizer is an algorithm used to reduce the colors of an image
+// to a small palette.
+type Quantizer string
+
+const (
+	// MedianCut recursively splits the color space at the median of its
+	// widest channel.
+	MedianCut Quantizer = "median-cut"
+	// Octree merges the least used branches of an octree of colors.
+	Octree Quantizer = "octree"
+)
+
+// quantize returns a palette of at most n colors representing the opaque
+// pixels of m.
+func quantize(m image.Image, n int, q Quantizer) (color.Palette, error) {
+	hist := histogram(m)
+	switch q {
+	case MedianCut:
+		return medianCut(hist, n), nil
+	case Octree:
+		return octree(hist, n), nil
+	default:
+		return nil, fmt.Errorf("unknown quantizer %q", q)
+	}
+}
+
+// colorCount is a color and how many pixels have it.
+type colorCount struct {
+	c     [3]uint8
+	count int
+}
+
+// histogram returns the distinct opaque colors of m sorted by value so
+// the result does not depend on map iteration order.
+func histogram(m image.Image) []colorCount {
+	counts := make(map[[3]uint8]int)
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				continue
+			}
+			counts[[3]uint8{c.R, c.G, c.B}]++
+		}
+	}
+	hist := make([]colorCount, 0, len(counts))
+	for c, n := range counts {
+		hist = append(hist, colorCount{c, n})
+	}
+	sort.Slice(hist, func(i, j int) bool {
+		a, b := hist[i].c, hist[j].c
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	return hist
+}
+
+// average returns the color resulting of averaging the given colors,
+// weighted by their counts.
+func average(cs []colorCount) color.Color {
+	var sum [3]int
+	total := 0
+	for _, c := range cs {
+		for i := range sum {
+			sum[i] += int(c.c[i]) * c.count
+		}
+		total += c.count
+	}
+	if total == 0 {
+		return color.RGBA{A: 0xff}
+	}
+	return color.RGBA{
+		R: uint8((sum[0] + total/2) / total),
+		G: uint8((sum[1] + total/2) / total),
+		B: uint8((sum[2] + total/2) / total),
+		A: 0xff,
+	}
+}
+
+func medianCut(hist []colorCount, n int) color.Palette {
+	boxes := [][]colorCount{hist}
+	for len(boxes) < n {
+		// Split the box with the widest channel range.
+		best, bestCh, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			ch, r := widestChannel(box)
+			if r > bestRange {
+				best, bestCh, bestRange = i, ch, r
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.SliceStable(box, func(i, j int) bool { return box[i].c[bestCh] < box[j].c[bestCh] })
+		total := 0
+		for _, c := range box {
+			total += c.count
+		}
+		mid, acc := 1, 0
+		for i, c := range box[:len(box)-1] {
+			acc += c.count
+			mid = i + 1
+			if acc*2 >= total {
+				break
+			}
+		}
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	var p color.Palette
+	for _, box := range boxes {
+		if len(box) > 0 {
+			p = append(p, average(box))
+		}
+	}
+	return p
+}
+
+// widestChannel returns the channel with the widest range in the given colors.
+func widestChannel(cs []colorCount) (ch, r int) {
+	for i := 0; i < 3; i++ {
+		lo, hi := 255, 0
+		for _, c := range cs {
+			v := int(c.c[i])
+			if v < lo {
+				lo = v
+			}
+			if v > hi {
+				hi = v
+			}
+		}
+		if hi-lo > r {
+			ch, r = i, hi-lo
+		}
+	}
+	return ch, r
+}
+
+const octreeDepth = 8
+
+type octreeNode struct {
+	children [8]*octreeNode
+	leaf     bool
+	colors   []colorCount
+}
+
+func octreeIndex(c [3]uint8, level int) int {
+	shift := uint(7 - level)
+	return int(c[0]>>shift&1)<<2 | int(c[1]>>shift&1)<<1 | int(c[2]>>shift&1)
+}
+
+func octree(hist []colorCount, n int) color.Palette {
+	root := &octreeNode{}
+	var levels [octreeDepth][]*octreeNode
+	leaves := 0
+	for _, c := range hist {
+		node := root
+		for level := 0; level < octreeDepth; level++ {
+			i := octreeIndex(c.c, level)
+			if node.children[i] == nil {
+				child := &octreeNode{leaf: level == octreeDepth-1}
+				node.children[i] = child
+				if child.leaf {
+					leaves++
+				} else {
+					levels[level+1] = append(levels[level+1], child)
+				}
+			}
+			node = node.children[i]
+		}
+		node.colors = append(node.colors, c)
+	}
+	levels[0] = []*octreeNode{root}
+
+	// Merge the deepest, least used nodes until there are few enough leaves.
+	for level := octreeDepth - 1; level >= 0 && leaves > n; level-- {
+		nodes := levels[level]
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].count() < nodes[j].count() })
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			merged := 0
+			for i, child := range node.children {
+				if child == nil {
+					continue
+				}
+				node.colors = append(node.colors, child.all()...)
+				node.children[i] = nil
+				merged++
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+	}
+
+	var p color.Palette
+	root.walk(func(node *octreeNode) {
+		if len(node.colors) > 0 {
+			p = append(p, average(node.colors))
+		}
+	})
+	return p
+}
+
+func (node *octreeNode) count() int {
+	n := 0
+	for _, c := range node.all() {
+		n += c.count
+	}
+	return n
+}
+
+// all returns the colors stored under the node.
+func (node *octreeNode) all() []colorCount {
+	cs := node.colors
+	for _, child := range node.children {
+		if child != nil {
+			cs = append(cs[:len(cs):len(cs)], child.all()...)
+		}
+	}
+	return cs
+}
+
+func (node *octreeNode) walk(f func(*octreeNode)) {
+	if node.leaf {
+		f(node)
+		return
+	}
+	for _, child := range node.children {
+		if child != nil {
+			child.walk(f)
+		}
+	}
+}
+
+// paletted maps every pixel of m to the closest color in p, optionally
+// diffusing the quantization error with the Floyd–Steinberg algorithm.
+// Transparent pixels are mapped to -1.
+func paletted(m image.Image, p color.Palette, dither bool) [][]int {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([][]int, h)
+
+	// Quantization errors for the current and next rows.
+	cur, next := make([][3]float64, w+2), make([][3]float64, w+2)
+	cache := make(map[[3]uint8]int)
+	for y := 0; y < h; y++ {
+		out[y] = make([]int, w)
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(m.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			if c.A < 0x80 || len(p) == 0 {
+				out[y][x] = -1
+				continue
+			}
+			v := [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+			if dither {
+				for i := range v {
+					v[i] = clamp(v[i] + cur[x+1][i])
+				}
+			}
+			key := [3]uint8{uint8(v[0] + 0.5), uint8(v[1] + 0.5), uint8(v[2] + 0.5)}
+			idx, ok := cache[key]
+			if !ok {
+				idx = p.Index(color.RGBA{key[0], key[1], key[2], 0xff})
+				cache[key] = idx
+			}
+			out[y][x] = idx
+			if !dither {
+				continue
+			}
+			pr, pg, pb, _ := p[idx].RGBA()
+			e := [3]float64{v[0] - float64(pr>>8), v[1] - float64(pg>>8), v[2] - float64(pb>>8)}
+			for i := range e {
+				cur[x+2][i] += e[i] * 7 / 16
+				next[x][i] += e[i] * 3 / 16
+				next[x+1][i] += e[i] * 5 / 16
+				next[x+2][i] += e[i] * 1 / 16
+			}
+		}
+		cur, next = next, cur
+		for i := range next {
+			next[i] = [3]float64{}
+		}
+	}
+	return out
+}
+
+func clamp(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
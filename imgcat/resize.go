@@ -0,0 +1,313 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// A Filter is a resampling filter used to resize images.
+type Filter string
+
+const (
+	// Bilinear interpolates linearly between the closest pixels.
+	// It is fast but blurry.
+	Bilinear Filter = "bilinear"
+	// CatmullRom is a cubic filter, sharp and with few artifacts.
+	CatmullRom Filter = "catmull-rom"
+	// Lanczos is a windowed sinc filter of three lobes, the sharpest
+	// and slowest of the filters.
+	Lanczos Filter = "lanczos"
+)
+
+// kernel is a resampling function and the distance where it becomes zero.
+type kernel struct {
+	support float64
+	f       func(x float64) float64
+}
+
+var kernels = map[Filter]kernel{
+	Bilinear: {1, func(x float64) float64 {
+		return 1 - math.Abs(x)
+	}},
+	CatmullRom: {2, func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return (1.5*x-2.5)*x*x + 1
+		}
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}},
+	Lanczos: {3, func(x float64) float64 {
+		if x == 0 {
+			return 1
+		}
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}},
+}
+
+// Resample set causes the iTerm2 and kitty protocols to resize images
+// locally to the size they will be displayed at, so less data is sent
+// to the terminal. It also sets the filter used by the Sixel protocol.
+// Defaults to no resizing, and CatmullRom for Sixel.
+func Resample(f Filter) Option {
+	return Option{"resample", string(f), func(o *Options) { o.Resample = f }}
+}
+
+// MaxBytes sets the maximum size of the encoded image sent to the iTerm2
+// and kitty protocols. Images are resized locally until they fit.
+func MaxBytes(n int) Option {
+	return Option{"maxBytes", fmt.Sprint(n), func(o *Options) { o.MaxBytes = n }}
+}
+
+// CellSize sets the size in pixels of a cell of the terminal, used to
+// compute the size of images in cells. Defaults to the size reported by
+// the terminal the encoder writes to, or 8x16 when unknown.
+func CellSize(width, height int) Option {
+	value := fmt.Sprintf("%dx%d", width, height)
+	return Option{"cellSize", value, func(o *Options) { o.CellWidth, o.CellHeight = width, height }}
+}
+
+// Cell size in pixels assumed when it's unknown.
+const (
+	defaultCellWidth  = 8
+	defaultCellHeight = 16
+)
+
+// Terminal size in cells assumed when it's unknown.
+const (
+	defaultColumns = 80
+	defaultRows    = 24
+)
+
+// cellPixels returns the size of a cell in pixels.
+func (enc *Encoder) cellPixels() (w, h int) { return enc.window().Cell() }
+
+// resizing reports whether images should be resized locally for the
+// protocols that can send them untouched.
+func (enc *Encoder) resizing() bool {
+	o := enc.opts()
+	return o.Resample != "" || o.MaxBytes > 0
+}
+
+func (enc *Encoder) filter() Filter {
+	if f := enc.opts().Resample; f != "" {
+		return f
+	}
+	return CatmullRom
+}
+
+// encodeResized sends the file untouched if it's small enough, or resizes
+// the image it contains otherwise.
+func (enc *Encoder) encodeResized(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	budget := enc.opts().MaxBytes
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Not an image, it can still be downloaded by iTerm2.
+		return enc.encodeFile(bytes.NewReader(data))
+	}
+	if cfg.ColorModel != nil && jpegOrientation(data).swapsAxes() {
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+	w, h := enc.pixelSize(image.Rect(0, 0, cfg.Width, cfg.Height))
+	if w >= cfg.Width && h >= cfg.Height && (budget == 0 || len(data) <= budget) {
+		return enc.encodeFile(bytes.NewReader(data))
+	}
+	m, _, err := DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+	return enc.EncodeImage(m)
+}
+
+// fit resizes m to the size it will be displayed at, if smaller, and then
+// until its encoding fits in the maximum number of bytes.
+// It returns the resized image and its encoding.
+func (enc *Encoder) fit(m image.Image, encode func(image.Image) ([]byte, error)) (image.Image, []byte, error) {
+	if !enc.resizing() {
+		data, err := encode(m)
+		return m, data, err
+	}
+	f, budget := enc.filter(), enc.opts().MaxBytes
+
+	b := m.Bounds()
+	w, h := enc.pixelSize(b)
+	w, h = min(w, b.Dx()), min(h, b.Dy())
+	for {
+		resized := Resize(m, w, h, f)
+		data, err := encode(resized)
+		if err != nil || budget == 0 || len(data) <= budget {
+			return resized, data, err
+		}
+		if w == 1 && h == 1 {
+			return nil, nil, fmt.Errorf("image does not fit in %d bytes", budget)
+		}
+		s := 0.9 * math.Sqrt(float64(budget)/float64(len(data)))
+		w, h = max(1, int(float64(w)*s)), max(1, int(float64(h)*s))
+	}
+}
+
+// pixelSize returns the size in pixels that an image with the given
+// bounds should be rendered at, according to the Width, Height, and
+// PreserveAspectRatio options. Auto lengths keep the inherent size.
+func (enc *Encoder) pixelSize(b image.Rectangle) (w, h int) {
+	win := enc.window()
+	o := enc.opts()
+	w, err := win.Pixels(o.Width, Horizontal)
+	okw := err == nil && w > 0
+	h, err = win.Pixels(o.Height, Vertical)
+	okh := err == nil && h > 0
+	iw, ih := b.Dx(), b.Dy()
+	if iw == 0 || ih == 0 {
+		return iw, ih
+	}
+	switch {
+	case !okw && !okh:
+		return iw, ih
+	case !okh:
+		return w, max(1, ih*w/iw)
+	case !okw:
+		return max(1, iw*h/ih), h
+	case !o.preserveAspectRatio():
+		return w, h
+	case w*ih < h*iw:
+		return w, max(1, ih*w/iw)
+	default:
+		return max(1, iw*h/ih), h
+	}
+}
+
+// Resize returns a copy of m scaled to the given size using the given filter.
+func Resize(m image.Image, w, h int, f Filter) image.Image {
+	b := m.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return m
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w <= 0 || h <= 0 || b.Empty() {
+		return dst
+	}
+	k, ok := kernels[f]
+	if !ok {
+		k = kernels[CatmullRom]
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), m, b.Min, draw.Src)
+	pix := make([]float64, len(src.Pix))
+	for i, v := range src.Pix {
+		pix[i] = float64(v)
+	}
+
+	// Resize horizontally and then vertically, with premultiplied colors.
+	sw, sh := b.Dx(), b.Dy()
+	tmp := make([]float64, 4*w*sh)
+	for i, c := range weights(sw, w, k) {
+		for y := 0; y < sh; y++ {
+			for j, wt := range c.w {
+				s, d := 4*(y*sw+c.start+j), 4*(y*w+i)
+				for ch := 0; ch < 4; ch++ {
+					tmp[d+ch] += wt * pix[s+ch]
+				}
+			}
+		}
+	}
+	out := make([]float64, 4*w*h)
+	for i, c := range weights(sh, h, k) {
+		for x := 0; x < w; x++ {
+			for j, wt := range c.w {
+				s, d := 4*((c.start+j)*w+x), 4*(i*w+x)
+				for ch := 0; ch < 4; ch++ {
+					out[d+ch] += wt * tmp[s+ch]
+				}
+			}
+		}
+	}
+
+	for i := 0; i < len(out); i += 4 {
+		a := clamp(out[i+3])
+		if a == 0 {
+			continue
+		}
+		for ch := 0; ch < 3; ch++ {
+			dst.Pix[i+ch] = uint8(clamp(out[i+ch]*255/a) + 0.5)
+		}
+		dst.Pix[i+3] = uint8(a + 0.5)
+	}
+	return dst
+}
+
+// contribution are the weights of consecutive source pixels, starting at
+// start, to a destination pixel.
+type contribution struct {
+	start int
+	w     []float64
+}
+
+// weights computes the contributions to each of the dst pixels from the
+// src pixels. Pixels out of the image are ignored.
+func weights(src, dst int, k kernel) []contribution {
+	scale := float64(src) / float64(dst)
+	// When shrinking the kernel is stretched to cover all source pixels.
+	stretch := math.Max(scale, 1)
+	support := k.support * stretch
+
+	cs := make([]contribution, dst)
+	for i := range cs {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := int(math.Ceil(center - support))
+		hi := int(math.Floor(center + support))
+		lo, hi = max(lo, 0), min(hi, src-1)
+		ws := make([]float64, hi-lo+1)
+		sum := 0.0
+		for j := range ws {
+			ws[j] = k.f((float64(lo+j) - center) / stretch)
+			sum += ws[j]
+		}
+		if sum == 0 {
+			// Happens only when the image is a single pixel wide.
+			ws, sum = []float64{1}, 1
+			lo = max(0, min(int(center+0.5), src-1))
+		}
+		for j := range ws {
+			ws[j] /= sum
+		}
+		cs[i] = contribution{lo, ws}
+	}
+	return cs
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
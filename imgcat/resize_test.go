@@ -0,0 +1,170 @@
+package imgcat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestResize(t *testing.T) {
+	uniform := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	c := color.NRGBA{0x20, 0x80, 0xc0, 0xff}
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 30; y++ {
+			uniform.Set(x, y, c)
+		}
+	}
+
+	for _, f := range []Filter{Bilinear, CatmullRom, Lanczos} {
+		for _, size := range []image.Point{{10, 5}, {1, 1}, {80, 45}, {40, 1}} {
+			m := Resize(uniform, size.X, size.Y, f)
+			if got := m.Bounds().Size(); got != size {
+				t.Errorf("%s: expected size %v; got %v", f, size, got)
+				continue
+			}
+			for x := 0; x < size.X; x++ {
+				for y := 0; y < size.Y; y++ {
+					if got := color.NRGBAModel.Convert(m.At(x, y)); got != c {
+						t.Fatalf("%s to %v: expected color %v at %d,%d; got %v", f, size, c, x, y, got)
+					}
+				}
+			}
+		}
+	}
+
+	// Transparent pixels don't darken their neighbors.
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	m.Set(0, 0, color.NRGBA{0xff, 0, 0, 0xff})
+	got := color.NRGBAModel.Convert(Resize(m, 1, 1, Bilinear).At(0, 0)).(color.NRGBA)
+	if got.R != 0xff || got.A == 0 || got.A == 0xff {
+		t.Errorf("expected semi transparent red; got %v", got)
+	}
+}
+
+func TestPixelSize(t *testing.T) {
+	defer func(old string) { check(t, os.Setenv("COLUMNS", old)) }(os.Getenv("COLUMNS"))
+	defer func(old string) { check(t, os.Setenv("LINES", old)) }(os.Getenv("LINES"))
+	check(t, os.Setenv("COLUMNS", "100"))
+	check(t, os.Setenv("LINES", "50"))
+
+	tc := []struct {
+		name    string
+		options []Option
+		w, h    int
+	}{
+		{"auto", nil, 400, 200},
+		{"width in pixels", []Option{Width(Pixels(100))}, 100, 50},
+		{"width in cells", []Option{Width(Cells(10))}, 80, 40},
+		{"width in cells of custom size", []Option{Width(Cells(10)), CellSize(10, 20)}, 100, 50},
+		{"width in percent", []Option{Width(Percent(50))}, 400, 200},
+		{"height in percent", []Option{Height(Percent(10))}, 160, 80},
+		{"fit", []Option{Width(Pixels(100)), Height(Pixels(10))}, 20, 10},
+		{"stretch", []Option{Width(Pixels(100)), Height(Pixels(10)), PreserveAspectRatio(false)}, 100, 10},
+	}
+	for _, tt := range tc {
+		enc := &Encoder{options: tt.options}
+		w, h := enc.pixelSize(image.Rect(0, 0, 400, 200))
+		if w != tt.w || h != tt.h {
+			t.Errorf("%s: expected %dx%d; got %dx%d", tt.name, tt.w, tt.h, w, h)
+		}
+	}
+}
+
+// sentImage decodes the image sent in an iTerm2 escape sequence.
+func sentImage(t *testing.T, out string) (image.Image, string) {
+	match := regexp.MustCompile("File=([^:]*):([^\a]*)\a").FindStringSubmatch(out)
+	if match == nil {
+		t.Fatalf("no image in %q", out)
+	}
+	data, err := base64.StdEncoding.DecodeString(match[2])
+	if err != nil {
+		t.Fatalf("could not decode payload: %v", err)
+	}
+	m, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not decode png: %v", err)
+	}
+	return m, match[1]
+}
+
+func TestEncodeResized(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 128, 64)
+
+	tc := []struct {
+		name    string
+		options []Option
+		w, h    int
+	}{
+		{"no resizing", []Option{Width(Pixels(32))}, 128, 64},
+		{"shrink", []Option{Width(Pixels(32)), Resample(Lanczos)}, 32, 16},
+		{"shrink to cells", []Option{Width(Cells(4)), Resample(CatmullRom)}, 32, 16},
+		{"never enlarge", []Option{Width(Pixels(1000)), Resample(Bilinear)}, 128, 64},
+		{"budget", []Option{MaxBytes(2000)}, 0, 0},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		enc, err := NewProtocolEncoder(&buf, ITerm2, tt.options...)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.Encode(bytes.NewReader(img)); err != nil {
+			t.Fatalf("%s: could not encode: %v", tt.name, err)
+		}
+		m, header := sentImage(t, buf.String())
+		if strings.Contains(header, "resample") || strings.Contains(header, "maxBytes") {
+			t.Errorf("%s: options for resizing sent to the terminal: %q", tt.name, header)
+		}
+		size := m.Bounds().Size()
+		if tt.w == 0 {
+			if size.X >= 128 || len(buf.String()) > 4*2000/3+200 {
+				t.Errorf("%s: image of size %v was not reduced", tt.name, size)
+			}
+			continue
+		}
+		if size.X != tt.w || size.Y != tt.h {
+			t.Errorf("%s: expected size %dx%d; got %v", tt.name, tt.w, tt.h, size)
+		}
+	}
+}
+
+func TestEncodeResizedUntouched(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	img := testPNG(t, 16, 16)
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, ITerm2, Width(Pixels(32)), Resample(CatmullRom), MaxBytes(1<<20))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.Encode(bytes.NewReader(img)); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	want := "\x1b]1337;File=width=32px:" + base64.StdEncoding.EncodeToString(img) + "\a\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected the file untouched %q; got %q", want, got)
+	}
+}
+
+func TestEncodeResizedErrors(t *testing.T) {
+	img := testPNG(t, 16, 16)
+	tc := []Option{Resample("foo"), MaxBytes(-1), MaxBytes(1)}
+	for _, o := range tc {
+		enc, err := NewProtocolEncoder(&bytes.Buffer{}, ITerm2, Width(Pixels(8)), o)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.Encode(bytes.NewReader(img)); err == nil {
+			t.Errorf("expected error with option %s", o)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// Maximum number of color registers used by the sixel encoder.
+const maxSixelColors = 256
+
+// Colors sets the size of the palette used by the Sixel protocol.
+// Defaults to 256, which is also the maximum.
+func Colors(n int) Option {
+	return Option{"colors", fmt.Sprint(n), func(o *Options) { o.Colors = n }}
+}
+
+// Quantization sets the algorithm used to compute the palette used by
+// the Sixel protocol. Defaults to MedianCut.
+func Quantization(q Quantizer) Option {
+	return Option{"quantizer", string(q), func(o *Options) { o.Quantizer = q }}
+}
+
+// Dither set to true causes the Sixel protocol to diffuse the
+// quantization error using the Floyd–Steinberg algorithm.
+// Defaults to true.
+func Dither(b bool) Option {
+	return Option{"dither", fmt.Sprint(boolToInt(b)), func(o *Options) { o.Dither = &b }}
+}
+
+// encodeSixel decodes the image and writes it as a sixel stream.
+func (enc *Encoder) encodeSixel(r io.Reader) error {
+	m, _, err := DecodeImage(r)
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+	return enc.writeSixel(m)
+}
+
+func (enc *Encoder) writeSixel(m image.Image) error {
+	m = enc.composite(m)
+	o := enc.opts()
+	colors := maxSixelColors
+	if o.Colors != 0 {
+		colors = o.Colors
+	}
+	q := MedianCut
+	if o.Quantizer != "" {
+		q = o.Quantizer
+	}
+
+	w, h := enc.pixelSize(m.Bounds())
+	m = Resize(m, w, h, enc.filter())
+	p, err := quantize(m, colors, q)
+	if err != nil {
+		return err
+	}
+	pixels := paletted(m, p, o.dither())
+
+	buf := new(bytes.Buffer)
+	// P2=1 keeps the pixels that are not drawn, making transparency work.
+	fmt.Fprintf(buf, "\x1bP0;1;0q\"1;1;%d;%d", w, h)
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(buf, "#%d;2;%d;%d;%d", i, percent(r), percent(g), percent(b))
+	}
+	for y := 0; y < h; y += 6 {
+		writeSixelBand(buf, pixels[y:min(y+6, h)], len(p))
+		buf.WriteByte('-')
+	}
+	buf.WriteString("\x1b\\")
+
+	_, err = io.WriteString(enc.out, enc.multiplexer().Passthrough(buf.String())+"\n")
+	return err
+}
+
+// writeSixelBand writes a band of at most six rows of pixels, one pass per
+// color used in the band.
+func writeSixelBand(buf *bytes.Buffer, rows [][]int, colors int) {
+	if len(rows) == 0 {
+		return
+	}
+	w := len(rows[0])
+	first := true
+	for c := 0; c < colors; c++ {
+		line := make([]byte, w)
+		used := false
+		for x := 0; x < w; x++ {
+			var bits byte
+			for i, row := range rows {
+				if row[x] == c {
+					bits |= 1 << uint(i)
+				}
+			}
+			line[x] = '?' + bits
+			used = used || bits != 0
+		}
+		if !used {
+			continue
+		}
+		if !first {
+			buf.WriteByte('$')
+		}
+		first = false
+		fmt.Fprintf(buf, "#%d", c)
+		writeSixelRLE(buf, bytes.TrimRight(line, "?"))
+	}
+}
+
+// writeSixelRLE writes the sixels compressing runs of the same character.
+func writeSixelRLE(buf *bytes.Buffer, line []byte) {
+	for i := 0; i < len(line); {
+		j := i + 1
+		for j < len(line) && line[j] == line[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(buf, "!%d%c", n, line[i])
+		} else {
+			buf.WriteString(strings.Repeat(string(line[i]), n))
+		}
+		i = j
+	}
+}
+
+// percent converts a 16 bits color channel to the 0-100 range used by sixel.
+func percent(v uint32) int { return int((v*100 + 0x7fff) / 0xffff) }
@@ -0,0 +1,148 @@
+package imgcat
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// GoldenFile is imgtest.Golden, set by golden_test.go, as imgtest can't be
+// imported by the tests of this package.
+var GoldenFile func(t testing.TB, golden string, got []byte) bool
+
+// golden compares got with the contents of testdata/name, or rewrites the
+// file when the -update flag is set.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	GoldenFile(t, filepath.Join("testdata", name), got)
+}
+
+func TestSixelGolden(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	tc := []struct {
+		golden  string
+		options []Option
+	}{
+		{"icon.sixel", nil},
+		{"icon-16-median-cut.sixel", []Option{Colors(16), Quantization(MedianCut), Dither(false)}},
+		{"icon-16-octree.sixel", []Option{Colors(16), Quantization(Octree), Dither(false)}},
+		{"icon-8-dither.sixel", []Option{Colors(8), Dither(true)}},
+		{"icon-width-10px.sixel", []Option{Width(Pixels(10)), Colors(16)}},
+		{"icon-height-2-cells.sixel", []Option{Height(Cells(2)), Colors(16)}},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.golden, func(t *testing.T) {
+			f, err := os.Open("testdata/icon.png")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { check(t, f.Close()) }()
+
+			var buf bytes.Buffer
+			enc, err := NewProtocolEncoder(&buf, Sixel, tt.options...)
+			if err != nil {
+				t.Fatalf("could not create encoder: %v", err)
+			}
+			if err := enc.Encode(f); err != nil {
+				t.Fatalf("could not encode: %v", err)
+			}
+			golden(t, tt.golden, buf.Bytes())
+		})
+	}
+}
+
+func TestSixelStream(t *testing.T) {
+	defer func() { check(t, os.Unsetenv("TMUX_TEST")) }()
+	check(t, os.Setenv("TMUX_TEST", "false"))
+
+	// Two columns of 7 pixels: red and transparent.
+	m := image.NewNRGBA(image.Rect(0, 0, 2, 7))
+	for y := 0; y < 7; y++ {
+		m.Set(0, y, color.NRGBA{0xff, 0, 0, 0xff})
+	}
+	var buf bytes.Buffer
+	enc, err := NewProtocolEncoder(&buf, Sixel)
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if err := enc.writeSixel(m); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	want := "\x1bP0;1;0q\"1;1;2;7#0;2;100;0;0#0~-#0@-\x1b\\\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("expected output %q; got %q", want, got)
+	}
+}
+
+func TestSixelRLE(t *testing.T) {
+	tc := []struct{ in, out string }{
+		{"", ""},
+		{"~", "~"},
+		{"~~~", "~~~"},
+		{"~~~~", "!4~"},
+		{"??~~~~~@", "??!5~@"},
+	}
+	for _, tt := range tc {
+		var buf bytes.Buffer
+		writeSixelRLE(&buf, []byte(tt.in))
+		if got := buf.String(); got != tt.out {
+			t.Errorf("compressing %q expected %q; got %q", tt.in, tt.out, got)
+		}
+	}
+}
+
+func TestSixelBadOptions(t *testing.T) {
+	tc := []Option{Colors(1), Colors(257), Quantization("foo")}
+	for _, o := range tc {
+		enc, err := NewProtocolEncoder(ioutil.Discard, Sixel, o)
+		if err != nil {
+			t.Fatalf("could not create encoder: %v", err)
+		}
+		if err := enc.EncodeImage(image.NewRGBA(image.Rect(0, 0, 1, 1))); err == nil {
+			t.Errorf("expected error with option %s", o)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	m := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 64; y++ {
+			m.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 0x80, 0xff})
+		}
+	}
+	for _, q := range []Quantizer{MedianCut, Octree} {
+		for _, n := range []int{2, 16, 256} {
+			p, err := quantize(m, n, q)
+			if err != nil {
+				t.Fatalf("could not quantize: %v", err)
+			}
+			if len(p) == 0 || len(p) > n {
+				t.Errorf("%s with %d colors returned %d colors", q, n, len(p))
+			}
+		}
+	}
+
+	// An image with few colors keeps them all.
+	m = image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	cs := []color.NRGBA{{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}}
+	for x, c := range cs {
+		m.Set(x, 0, c)
+	}
+	for _, q := range []Quantizer{MedianCut, Octree} {
+		p, err := quantize(m, 16, q)
+		if err != nil {
+			t.Fatalf("could not quantize: %v", err)
+		}
+		if len(p) != 3 {
+			t.Errorf("%s expected 3 colors; got %v", q, p)
+		}
+	}
+}
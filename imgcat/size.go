@@ -0,0 +1,259 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imgcat
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Window is the size of a terminal in cells and in pixels.
+// The sizes in pixels are zero when unknown.
+type Window struct {
+	Columns, Rows int
+	Width, Height int
+}
+
+// Cell returns the size of a cell in pixels, or zero if unknown.
+func (w Window) Cell() (width, height int) {
+	if w.Columns <= 0 || w.Rows <= 0 {
+		return 0, 0
+	}
+	return w.Width / w.Columns, w.Height / w.Rows
+}
+
+// An Axis is the direction in which a length is measured.
+type Axis int
+
+const (
+	// Horizontal lengths are relative to the columns of the terminal.
+	Horizontal Axis = iota
+	// Vertical lengths are relative to the rows of the terminal.
+	Vertical
+)
+
+// axis returns the cells and pixels of the window along the axis.
+func (w Window) axis(a Axis) (cells, pixels int) {
+	if a == Vertical {
+		return w.Rows, w.Height
+	}
+	return w.Columns, w.Width
+}
+
+// Pixels converts a length to pixels along the given axis.
+func (w Window) Pixels(l Length, a Axis) (int, error) {
+	n, unit, err := parseLength(l)
+	if err != nil {
+		return 0, err
+	}
+	cells, pixels := w.axis(a)
+	switch unit {
+	case "px":
+		return n, nil
+	case "%":
+		if pixels <= 0 {
+			return 0, fmt.Errorf("unknown terminal size in pixels")
+		}
+		return pixels * n / 100, nil
+	default:
+		if cells <= 0 || pixels <= 0 {
+			return 0, fmt.Errorf("unknown cell size in pixels")
+		}
+		return n * pixels / cells, nil
+	}
+}
+
+// Cells converts a length to cells along the given axis, rounding up
+// lengths in pixels to whole cells.
+func (w Window) Cells(l Length, a Axis) (int, error) {
+	n, unit, err := parseLength(l)
+	if err != nil {
+		return 0, err
+	}
+	cells, pixels := w.axis(a)
+	switch unit {
+	case "px":
+		if cells <= 0 || pixels <= 0 {
+			return 0, fmt.Errorf("unknown cell size in pixels")
+		}
+		return (n*cells + pixels - 1) / pixels, nil
+	case "%":
+		if cells <= 0 {
+			return 0, fmt.Errorf("unknown terminal size in cells")
+		}
+		return cells * n / 100, nil
+	default:
+		return n, nil
+	}
+}
+
+// Percent converts a length to a percentage of the terminal size along
+// the given axis.
+func (w Window) Percent(l Length, a Axis) (int, error) {
+	n, unit, err := parseLength(l)
+	if err != nil {
+		return 0, err
+	}
+	cells, pixels := w.axis(a)
+	switch unit {
+	case "px":
+		if pixels <= 0 {
+			return 0, fmt.Errorf("unknown terminal size in pixels")
+		}
+		return n * 100 / pixels, nil
+	case "%":
+		return n, nil
+	default:
+		if cells <= 0 {
+			return 0, fmt.Errorf("unknown terminal size in cells")
+		}
+		return n * 100 / cells, nil
+	}
+}
+
+// parseLength returns the number and unit of a length: "px", "%", or
+// empty for cells. Auto lengths have no number and fail.
+func parseLength(l Length) (int, string, error) {
+	v, unit := string(l), ""
+	for _, u := range []string{"px", "%"} {
+		if strings.HasSuffix(v, u) {
+			v, unit = strings.TrimSuffix(v, u), u
+			break
+		}
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, "", fmt.Errorf("invalid length %q", l)
+	}
+	return n, unit, nil
+}
+
+// Queries sent by QueryWindow, terminated by the primary device attributes
+// query as in Probe.
+const (
+	queryWindowCells  = "\x1b[18t"
+	queryWindowPixels = "\x1b[14t"
+)
+
+var (
+	windowCellsResponse  = regexp.MustCompile("\x1b\\[8;([0-9]+);([0-9]+)t")
+	windowPixelsResponse = regexp.MustCompile("\x1b\\[4;([0-9]+);([0-9]+)t")
+)
+
+// QueryWindow asks the terminal for its size with the XTWINOPS queries.
+// The size in pixels of the text area is used when the terminal reports
+// it, and otherwise computed from the size of a cell.
+// The terminal should be in raw mode, as the one returned by OpenTTY.
+// QueryWindow returns whatever was answered when the timeout expires.
+func QueryWindow(tty io.ReadWriter, timeout time.Duration) (Window, error) {
+	var w Window
+	queries := queryWindowCells + queryWindowPixels + queryCellSize + queryAttribute
+	if _, err := io.WriteString(tty, queries); err != nil {
+		return w, err
+	}
+
+	res := readResponses(tty, timeout, func(b []byte) bool { return attributeResponse.Match(b) })
+	if m := windowCellsResponse.FindSubmatch(res); m != nil {
+		w.Rows, _ = strconv.Atoi(string(m[1]))
+		w.Columns, _ = strconv.Atoi(string(m[2]))
+	}
+	if m := windowPixelsResponse.FindSubmatch(res); m != nil {
+		w.Height, _ = strconv.Atoi(string(m[1]))
+		w.Width, _ = strconv.Atoi(string(m[2]))
+	}
+	if m := cellSizeResponse.FindSubmatch(res); m != nil && (w.Width == 0 || w.Height == 0) {
+		ch, _ := strconv.Atoi(string(m[1]))
+		cw, _ := strconv.Atoi(string(m[2]))
+		w.Width, w.Height = cw*w.Columns, ch*w.Rows
+	}
+	return w, nil
+}
+
+// Can be swapped for testing.
+var windowSize = WindowSize
+
+// TerminalWindow returns the size of the controlling terminal as reported
+// by the kernel. If the kernel doesn't know the size in pixels, as it's
+// often the case over ssh or in multiplexers, the terminal is queried.
+func TerminalWindow(timeout time.Duration) (Window, error) {
+	tty, err := OpenTTY()
+	if err != nil {
+		return Window{}, err
+	}
+	w, err := completeWindow(tty.File, tty, timeout)
+	if cerr := tty.Close(); err == nil {
+		err = cerr
+	}
+	return w, err
+}
+
+// completeWindow returns the size of the terminal f, querying tty for
+// whatever the kernel doesn't know.
+func completeWindow(f *os.File, tty io.ReadWriter, timeout time.Duration) (Window, error) {
+	w, err := windowSize(f)
+	if err == nil && w.Columns > 0 && w.Rows > 0 && w.Width > 0 && w.Height > 0 {
+		return w, nil
+	}
+	q, qerr := QueryWindow(tty, timeout)
+	if qerr != nil {
+		return w, qerr
+	}
+	if (w.Columns == 0 || w.Rows == 0) && q.Columns > 0 && q.Rows > 0 {
+		w.Columns, w.Rows = q.Columns, q.Rows
+	}
+	if q.Width > 0 && q.Height > 0 {
+		w.Width, w.Height = q.Width, q.Height
+	}
+	if w.Columns == 0 || w.Rows == 0 {
+		return w, fmt.Errorf("could not get terminal size: %v", err)
+	}
+	return w, nil
+}
+
+// window returns the size of the terminal the encoder writes to, as far
+// as the kernel knows it, completed with the COLUMNS and LINES environment
+// variables, the CellSize option, and defaults.
+func (enc *Encoder) window() Window {
+	var w Window
+	if f, ok := enc.out.(*os.File); ok {
+		w, _ = windowSize(f) // unknown sizes are filled below.
+	}
+	if w.Columns <= 0 || w.Rows <= 0 {
+		w.Columns = envSize("COLUMNS", defaultColumns)
+		w.Rows = envSize("LINES", defaultRows)
+		w.Width, w.Height = 0, 0
+	}
+	o := enc.opts()
+	cw, ch := o.CellWidth, o.CellHeight
+	if cw <= 0 || ch <= 0 {
+		cw, ch = w.Cell()
+	}
+	if cw <= 0 || ch <= 0 {
+		cw, ch = defaultCellWidth, defaultCellHeight
+	}
+	w.Width, w.Height = cw*w.Columns, ch*w.Rows
+	return w
+}
+
+func envSize(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
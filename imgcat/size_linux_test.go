@@ -0,0 +1,65 @@
+package imgcat
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// openPTY opens a pseudo terminal of the given size, returning its master
+// and slave ends.
+func openPTY(t *testing.T, w Window) (master, slave *os.File) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("could not open pty: %v", err)
+	}
+	var unlock int32
+	check(t, ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))))
+	var n uint32
+	check(t, ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))))
+	ws := struct{ row, col, xpixel, ypixel uint16 }{uint16(w.Rows), uint16(w.Columns), uint16(w.Width), uint16(w.Height)}
+	check(t, ioctl(master, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws))))
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close() // safe to ignore this error.
+		t.Skipf("could not open pty slave: %v", err)
+	}
+	return master, slave
+}
+
+func TestWindowSize(t *testing.T) {
+	want := Window{Columns: 120, Rows: 40, Width: 1080, Height: 800}
+	master, slave := openPTY(t, want)
+	defer func() { check(t, master.Close()) }()
+	defer func() { check(t, slave.Close()) }()
+
+	got, err := WindowSize(slave)
+	if err != nil {
+		t.Fatalf("could not get window size: %v", err)
+	}
+	if got != want {
+		t.Errorf("expected %+v; got %+v", want, got)
+	}
+
+	enc, err := NewProtocolEncoder(slave, ITerm2, Width(Cells(10)))
+	if err != nil {
+		t.Fatalf("could not create encoder: %v", err)
+	}
+	if w, h := enc.pixelSize(image.Rect(0, 0, 400, 200)); w != 90 || h != 45 {
+		t.Errorf("expected 90x45 pixels with 9x20 cells; got %dx%d", w, h)
+	}
+}
+
+func TestWindowSizeNotTerminal(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", os.DevNull, err)
+	}
+	defer func() { check(t, f.Close()) }()
+	if _, err := WindowSize(f); err == nil {
+		t.Errorf("expected error getting the size of %s", os.DevNull)
+	}
+}
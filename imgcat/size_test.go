@@ -0,0 +1,134 @@
+package imgcat
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWindowLengths(t *testing.T) {
+	w := Window{Columns: 100, Rows: 50, Width: 800, Height: 1000}
+	tc := []struct {
+		l                      Length
+		a                      Axis
+		pixels, cells, percent int
+	}{
+		{Pixels(100), Horizontal, 100, 13, 12},
+		{Pixels(100), Vertical, 100, 5, 10},
+		{Cells(10), Horizontal, 80, 10, 10},
+		{Cells(10), Vertical, 200, 10, 20},
+		{Percent(50), Horizontal, 400, 50, 50},
+		{Percent(10), Vertical, 100, 5, 10},
+	}
+	for _, tt := range tc {
+		if got, err := w.Pixels(tt.l, tt.a); err != nil || got != tt.pixels {
+			t.Errorf("%s along %d: expected %d pixels; got %d, %v", tt.l, tt.a, tt.pixels, got, err)
+		}
+		if got, err := w.Cells(tt.l, tt.a); err != nil || got != tt.cells {
+			t.Errorf("%s along %d: expected %d cells; got %d, %v", tt.l, tt.a, tt.cells, got, err)
+		}
+		if got, err := w.Percent(tt.l, tt.a); err != nil || got != tt.percent {
+			t.Errorf("%s along %d: expected %d%%; got %d, %v", tt.l, tt.a, tt.percent, got, err)
+		}
+	}
+
+	if _, err := w.Pixels(Auto(), Horizontal); err == nil {
+		t.Errorf("expected error converting auto")
+	}
+	if _, err := (Window{Columns: 80, Rows: 24}).Pixels(Cells(1), Horizontal); err == nil {
+		t.Errorf("expected error converting cells without pixel sizes")
+	}
+	if n, err := (Window{Columns: 80, Rows: 24}).Cells(Percent(50), Horizontal); err != nil || n != 40 {
+		t.Errorf("expected 40 cells without pixel sizes; got %d, %v", n, err)
+	}
+}
+
+func TestQueryWindow(t *testing.T) {
+	tc := []struct {
+		name      string
+		responses map[string]string
+		want      Window
+	}{
+		{"pixels", map[string]string{
+			queryWindowCells:  "\x1b[8;24;80t",
+			queryWindowPixels: "\x1b[4;480;720t",
+			queryCellSize:     "\x1b[6;20;9t",
+			queryAttribute:    "\x1b[?62;c",
+		}, Window{Columns: 80, Rows: 24, Width: 720, Height: 480}},
+		{"cell size", map[string]string{
+			queryWindowCells: "\x1b[8;24;80t",
+			queryCellSize:    "\x1b[6;20;9t",
+			queryAttribute:   "\x1b[?62;c",
+		}, Window{Columns: 80, Rows: 24, Width: 720, Height: 480}},
+		{"no answer", map[string]string{
+			queryAttribute: "\x1b[?62;c",
+		}, Window{}},
+	}
+	for _, tt := range tc {
+		tty := newFakeTTY(tt.responses)
+		got, err := QueryWindow(tty, time.Second)
+		check(t, tty.Close())
+		if err != nil {
+			t.Fatalf("%s: could not query: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %+v; got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCompleteWindow(t *testing.T) {
+	defer func(old func(*os.File) (Window, error)) { windowSize = old }(windowSize)
+	responses := map[string]string{
+		queryWindowCells:  "\x1b[8;30;90t",
+		queryWindowPixels: "\x1b[4;600;900t",
+		queryAttribute:    "\x1b[?62;c",
+	}
+
+	tc := []struct {
+		name   string
+		kernel Window
+		err    error
+		want   Window
+	}{
+		{"kernel", Window{80, 24, 640, 384}, nil, Window{80, 24, 640, 384}},
+		{"no pixels", Window{Columns: 80, Rows: 24}, nil, Window{80, 24, 900, 600}},
+		{"no kernel", Window{}, fmt.Errorf("not a terminal"), Window{90, 30, 900, 600}},
+	}
+	for _, tt := range tc {
+		windowSize = func(*os.File) (Window, error) { return tt.kernel, tt.err }
+		tty := newFakeTTY(responses)
+		got, err := completeWindow(nil, tty, time.Second)
+		check(t, tty.Close())
+		if err != nil {
+			t.Fatalf("%s: could not get window: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %+v; got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestEncoderWindow(t *testing.T) {
+	defer func(old func(*os.File) (Window, error)) { windowSize = old }(windowSize)
+	windowSize = func(*os.File) (Window, error) { return Window{100, 50, 1000, 1000}, nil }
+
+	tc := []struct {
+		name    string
+		options []Option
+		w, h    int
+	}{
+		{"width in cells", []Option{Width(Cells(10))}, 100, 50},
+		{"height in percent", []Option{Height(Percent(10))}, 200, 100},
+		{"custom cell size", []Option{Width(Cells(10)), CellSize(8, 16)}, 80, 40},
+	}
+	for _, tt := range tc {
+		enc := &Encoder{out: os.Stdout, options: tt.options}
+		w, h := enc.pixelSize(image.Rect(0, 0, 400, 200))
+		if w != tt.w || h != tt.h {
+			t.Errorf("%s: expected %dx%d; got %dx%d", tt.name, tt.w, tt.h, w, h)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package transfer sends files between the machine running a program and
+// the one running iTerm2, through the terminal, as the it2dl and it2ul
+// scripts of iTerm2 do. It works over ssh and, with the same wrapping as
+// imgcat, in tmux.
+package transfer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/campoy/tools/imgcat"
+	"github.com/campoy/tools/imgcat/osc"
+)
+
+// Download sends what's read from r to iTerm2, which saves it with the
+// given name in the downloads folder. If size is positive, it must be the
+// number of bytes in r, and iTerm2 uses it to show the progress. Options
+// such as imgcat.ChunkSize are passed to the encoder.
+func Download(w io.Writer, r io.Reader, name string, size int64, options ...imgcat.Option) error {
+	opts := []imgcat.Option{imgcat.Name(name), imgcat.KeepMetadata(true)}
+	if size > 0 {
+		opts = append(opts, imgcat.Size(int(size)))
+	}
+	opts = append(append(opts, options...), imgcat.Inline(false))
+	enc, err := imgcat.NewProtocolEncoder(w, imgcat.ITerm2, opts...)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(r)
+}
+
+// ErrAborted is returned by Upload when the user cancels it.
+var ErrAborted = errors.New("upload aborted")
+
+// Upload asks iTerm2 for files to upload, writing to the terminal, and
+// extracts them in dir as they're read from it. iTerm2 sends them as
+// input, so the terminal should not echo it nor wait for whole lines.
+//
+// It returns the names of the files and directories extracted, relative to
+// dir, even if it fails after extracting some. Only directories and
+// regular files are extracted, overwriting existing files.
+func Upload(term io.ReadWriter, dir string) ([]string, error) {
+	if err := osc.Write(term, osc.RequestUpload{}); err != nil {
+		return nil, fmt.Errorf("could not request upload: %v", err)
+	}
+	r := bufio.NewReader(term)
+	status, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read upload status: %v", err)
+	}
+	switch status {
+	case "ok":
+	case "abort":
+		return nil, ErrAborted
+	default:
+		return nil, fmt.Errorf("unexpected upload status %q", status)
+	}
+
+	data := &dataReader{r: r}
+	names, err := extract(base64.NewDecoder(base64.StdEncoding, data), dir)
+	// Read the rest of the upload, so it's not left as input for the shell.
+	if _, derr := io.Copy(ioutil.Discard, data); err == nil && derr != nil {
+		err = fmt.Errorf("could not read upload: %v", derr)
+	}
+	return names, err
+}
+
+// readLine reads a line without its line ending.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// dataReader reads the lines of base64 data of an upload, up to the empty
+// line that ends it.
+type dataReader struct {
+	r    *bufio.Reader
+	line string
+	done bool
+}
+
+func (d *dataReader) Read(p []byte) (int, error) {
+	for d.line == "" {
+		if d.done {
+			return 0, io.EOF
+		}
+		line, err := readLine(d.r)
+		if err != nil {
+			return 0, err
+		}
+		d.line, d.done = line, line == ""
+	}
+	n := copy(p, d.line)
+	d.line = d.line[n:]
+	return n, nil
+}
+
+// extract extracts the gzipped tar file read from r into dir.
+func extract(r io.Reader, dir string) ([]string, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress upload: %v", err)
+	}
+	tr := tar.NewReader(zr)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, fmt.Errorf("could not read upload: %v", err)
+		}
+		name, err := cleanName(hdr.Name)
+		if err != nil {
+			return names, err
+		}
+		path := filepath.Join(dir, name)
+		perm := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, perm|0700)
+		case tar.TypeReg:
+			err = writeFile(path, tr, perm)
+		default:
+			// Links and special files are not extracted.
+			continue
+		}
+		if err != nil {
+			return names, fmt.Errorf("could not extract %s: %v", name, err)
+		}
+		if name != "." {
+			names = append(names, name)
+		}
+	}
+}
+
+// cleanName returns the name of a file in an upload as a path relative to
+// the directory it's extracted to, failing if it would be outside of it.
+func cleanName(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file name %q in upload", name)
+	}
+	return clean, nil
+}
+
+// writeFile writes the file with the contents read from r, creating the
+// directories it's in if needed.
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close() // the copy error is more relevant.
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,191 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	defer func() { os.Unsetenv("TMUX_TEST") }()
+	os.Setenv("TMUX_TEST", "false")
+
+	data := []byte("\xff\xd8\xff\xe1 not really a JPEG file")
+	for _, tt := range []struct {
+		size    int64
+		options []imgcat.Option
+	}{
+		{int64(len(data)), nil},
+		{0, []imgcat.Option{imgcat.ChunkSize(8)}},
+		{0, []imgcat.Option{imgcat.Inline(true)}},
+	} {
+		var buf bytes.Buffer
+		check(t, Download(&buf, bytes.NewReader(data), "notes.jpg", tt.size, tt.options...))
+
+		f, err := imgcat.NewDecoder(&buf, ioutil.Discard).Next()
+		check(t, err)
+		if f.Name != "notes.jpg" || f.Size != int(tt.size) || f.Inline {
+			t.Errorf("downloaded %s, %d bytes, inline %v; want notes.jpg, %d bytes, not inline", f.Name, f.Size, f.Inline, tt.size)
+		}
+		if !bytes.Equal(f.Data, data) {
+			t.Errorf("downloaded %q; want the file untouched", f.Data)
+		}
+	}
+}
+
+type file struct {
+	name, data string
+	dir        bool
+}
+
+// upload returns what iTerm2 sends when uploading the given files, with
+// the base64 data in lines of the given length and line ending.
+func upload(t *testing.T, files []file, width int, eol string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}
+		if f.dir {
+			hdr.Mode, hdr.Typeflag = 0755, tar.TypeDir
+		}
+		check(t, tw.WriteHeader(hdr))
+		_, err := io.WriteString(tw, f.data)
+		check(t, err)
+	}
+	check(t, tw.Close())
+	check(t, zw.Close())
+
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	s := "ok" + eol
+	for len(data) > width {
+		s += data[:width] + eol
+		data = data[width:]
+	}
+	return s + data + eol + eol
+}
+
+// fakeTerminal returns the input given and then fails.
+type fakeTerminal struct {
+	bytes.Buffer
+	in io.Reader
+}
+
+var errEndOfInput = errors.New("read past the end of the input")
+
+func (t *fakeTerminal) Read(p []byte) (int, error) {
+	n, err := t.in.Read(p)
+	if err == io.EOF {
+		err = errEndOfInput
+	}
+	return n, err
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "transfer")
+	check(t, err)
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestUpload(t *testing.T) {
+	defer func() { os.Unsetenv("TMUX_TEST") }()
+	os.Setenv("TMUX_TEST", "false")
+
+	files := []file{
+		{name: "./", dir: true},
+		{name: "notes.txt", data: "hello, world\n"},
+		{name: "photos/", dir: true},
+		{name: "photos/gopher.png", data: strings.Repeat("gopher", 100)},
+		{name: "empty/file", data: ""},
+	}
+	for _, eol := range []string{"\n", "\r\n"} {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		check(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("overwritten"), 0644))
+
+		term := &fakeTerminal{in: strings.NewReader(upload(t, files, 76, eol))}
+		names, err := Upload(term, dir)
+		check(t, err)
+
+		if got := term.String(); got != "\x1b]1337;RequestUpload=format=tgz\a" {
+			t.Errorf("requested upload with %q", got)
+		}
+		want := []string{"notes.txt", "photos", filepath.Join("photos", "gopher.png"), filepath.Join("empty", "file")}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("extracted %q; want %q", names, want)
+		}
+		for _, f := range files[1:] {
+			if f.dir {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(f.name)))
+			check(t, err)
+			if string(data) != f.data {
+				t.Errorf("%s contains %q; want %q", f.name, data, f.data)
+			}
+		}
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	defer func() { os.Unsetenv("TMUX_TEST") }()
+	os.Setenv("TMUX_TEST", "false")
+
+	// Random data so the upload is not compressed to a few lines.
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	long := upload(t, []file{{name: "a.txt", data: "a"}, {name: "b.bin", data: string(random)}}, 76, "\n")
+	tc := []struct {
+		name, in string
+		// files extracted before failing
+		names []string
+	}{
+		{"unknown status", "what\n", nil},
+		{"no status", "", nil},
+		{"not gzip", "ok\nbm90IGd6aXA=\n\n", nil},
+		{"truncated", long[:len(long)/2], []string{"a.txt"}},
+		{"absolute path", upload(t, []file{{name: "a.txt", data: "a"}, {name: "/etc/passwd", data: "x"}}, 76, "\n"), []string{"a.txt"}},
+		{"parent directory", upload(t, []file{{name: "../a.txt", data: "a"}}, 76, "\n"), nil},
+	}
+	for _, tt := range tc {
+		dir, cleanup := tempDir(t)
+		defer cleanup()
+		names, err := Upload(&fakeTerminal{in: strings.NewReader(tt.in)}, dir)
+		if err == nil || err == ErrAborted {
+			t.Errorf("%s: expected error, got %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: extracted %q; want %q", tt.name, names, tt.names)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(os.TempDir(), "a.txt")); err == nil {
+		t.Errorf("a file was extracted outside of the directory")
+	}
+}
+
+func TestUploadAborted(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	if _, err := Upload(&fakeTerminal{in: strings.NewReader("abort\n")}, dir); err != ErrAborted {
+		t.Errorf("expected ErrAborted, got %v", err)
+	}
+}
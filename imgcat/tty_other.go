@@ -0,0 +1,39 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package imgcat
+
+import (
+	"fmt"
+	"os"
+)
+
+// OpenTTY is not supported in this platform.
+func OpenTTY() (*TTY, error) {
+	return nil, fmt.Errorf("terminal probing is not supported on this platform")
+}
+
+// A TTY is a terminal in raw mode.
+type TTY struct {
+	*os.File
+}
+
+func (t *TTY) readsTimeOut() {}
+
+// WindowSize is not supported in this platform.
+func WindowSize(f *os.File) (Window, error) {
+	return Window{}, fmt.Errorf("terminal size is not supported on this platform")
+}
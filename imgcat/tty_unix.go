@@ -0,0 +1,87 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package imgcat
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// OpenTTY opens the controlling terminal in raw mode, so the responses to
+// queries can be read as soon as they arrive without being echoed.
+// Reads time out after a tenth of a second, returning no data.
+// Closing it restores the previous mode of the terminal.
+func OpenTTY() (*TTY, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var old syscall.Termios
+	if err := termios(f, ioctlGetTermios, &old); err != nil {
+		_ = f.Close() // safe to ignore this error.
+		return nil, err
+	}
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if err := termios(f, ioctlSetTermios, &raw); err != nil {
+		_ = f.Close() // safe to ignore this error.
+		return nil, err
+	}
+	return &TTY{File: f, old: old}, nil
+}
+
+// A TTY is a terminal in raw mode.
+type TTY struct {
+	*os.File
+	old syscall.Termios
+}
+
+// Reads of the terminal in raw mode return no data after a while.
+func (t *TTY) readsTimeOut() {}
+
+// Close restores the terminal mode and closes it.
+func (t *TTY) Close() error {
+	err := termios(t.File, ioctlSetTermios, &t.old)
+	if cerr := t.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func termios(f *os.File, req uintptr, t *syscall.Termios) error {
+	return ioctl(f, req, uintptr(unsafe.Pointer(t)))
+}
+
+func ioctl(f *os.File, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// WindowSize returns the size of the terminal f as reported by the kernel.
+func WindowSize(f *os.File) (Window, error) {
+	var ws struct{ row, col, xpixel, ypixel uint16 }
+	if err := ioctl(f, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return Window{}, err
+	}
+	return Window{Columns: int(ws.col), Rows: int(ws.row), Width: int(ws.xpixel), Height: int(ws.ypixel)}, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package viewer
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Names of the keys that aren't printable characters.
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyHome      = "home"
+	keyEnd       = "end"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdn"
+	keyEscape    = "esc"
+	keyEnter     = "enter"
+	keyBackspace = "backspace"
+	keyCtrlC     = "ctrl-c"
+	keyCtrlL     = "ctrl-l"
+)
+
+// Escape sequences sent by the terminal for special keys, in normal and
+// application cursor mode.
+var sequences = map[string]string{
+	"\x1b[A": keyUp, "\x1bOA": keyUp,
+	"\x1b[B": keyDown, "\x1bOB": keyDown,
+	"\x1b[C": keyRight, "\x1bOC": keyRight,
+	"\x1b[D": keyLeft, "\x1bOD": keyLeft,
+	"\x1b[H": keyHome, "\x1bOH": keyHome, "\x1b[1~": keyHome,
+	"\x1b[F": keyEnd, "\x1bOF": keyEnd, "\x1b[4~": keyEnd,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+}
+
+// parseKeys splits what was read from a terminal in raw mode in keys:
+// the names above, or the printable characters typed. Unknown escape
+// sequences are dropped.
+func parseKeys(b []byte) []string {
+	var keys []string
+	s := string(b)
+	for len(s) > 0 {
+		switch {
+		case s[0] == '\x1b':
+			n := sequenceLength(s)
+			if n == 1 {
+				keys = append(keys, keyEscape)
+			} else if k, ok := sequences[s[:n]]; ok {
+				keys = append(keys, k)
+			}
+			s = s[n:]
+			continue
+		case s[0] == 3:
+			keys = append(keys, keyCtrlC)
+		case s[0] == 12:
+			keys = append(keys, keyCtrlL)
+		case s[0] == 127 || s[0] == 8:
+			keys = append(keys, keyBackspace)
+		case s[0] == '\r' || s[0] == '\n':
+			keys = append(keys, keyEnter)
+		case s[0] >= ' ':
+			r, n := utf8.DecodeRuneInString(s)
+			keys = append(keys, string(r))
+			s = s[n:]
+			continue
+		}
+		s = s[1:]
+	}
+	return keys
+}
+
+// sequenceLength returns the length of the escape sequence at the start
+// of s: a CSI or SS3 sequence, or a lone escape.
+func sequenceLength(s string) int {
+	if len(s) < 2 {
+		return 1
+	}
+	switch s[1] {
+	case 'O':
+		if len(s) < 3 {
+			return 2
+		}
+		return 3
+	case '[':
+		// Parameters and intermediate bytes up to the final byte.
+		if i := strings.IndexFunc(s[2:], func(r rune) bool { return r >= 0x40 && r <= 0x7e }); i >= 0 {
+			return i + 3
+		}
+		return len(s)
+	}
+	return 1
+}
@@ -0,0 +1,472 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package viewer shows images full screen in the alternate screen of a
+// terminal, with keys to go through them, zoom, and pan.
+//
+// The keys are:
+//
+//	n, space, enter, page down    next image
+//	p, backspace, page up         previous image
+//	home, end                     first and last image
+//	+, =                          zoom in
+//	-                             zoom out
+//	f, 0                          fit the image to the screen
+//	1                             show the image at its actual size
+//	arrows, h, j, k, l            pan
+//	i                             show or hide the information overlay
+//	r, ctrl-l                     redraw the screen
+//	q, escape, ctrl-c             quit
+package viewer
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+// A Terminal is where the viewer draws and reads keys from, usually the
+// controlling terminal in raw mode, such as the one returned by
+// imgcat.OpenTTY.
+//
+// Read should return no data, rather than block, when no key is typed
+// for a short while, so the viewer can redraw on resize.
+type Terminal interface {
+	io.ReadWriter
+	// Size returns the size of the terminal. The sizes in pixels may be
+	// zero when unknown.
+	Size() (imgcat.Window, error)
+}
+
+// An Image is an image shown by the viewer and the information about the
+// file it was decoded from.
+type Image struct {
+	image.Image
+	Name   string
+	Format string
+	// Bytes is the size of the file.
+	Bytes int64
+}
+
+// Load reads the image file with the given path.
+func Load(path string) (*Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m, format, err := imgcat.DecodeImage(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %s: %v", path, err)
+	}
+	return &Image{Image: m, Name: filepath.Base(path), Format: format, Bytes: fi.Size()}, nil
+}
+
+// Cell size in pixels assumed when the terminal doesn't report it.
+const (
+	defaultCellWidth  = 8
+	defaultCellHeight = 16
+)
+
+// Zoom factor applied by each key press, and the largest size in pixels
+// of a pixel of the image on the screen.
+const (
+	zoomStep = 1.5
+	maxScale = 16
+)
+
+// Escape sequences to use the alternate screen and hide the cursor, to
+// clear the screen, and to delete kitty images.
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+	kittyDelete = "\x1b_Ga=d,q=2\x1b\\"
+)
+
+// state is what the viewer shows. A change in it requires a redraw.
+type state struct {
+	index int
+	// zoom is relative to the image fit to the screen.
+	zoom float64
+	// x and y are the center of the view, as fractions of the image size.
+	x, y float64
+	info bool
+}
+
+// A Viewer shows a list of images in a terminal.
+type Viewer struct {
+	term  Terminal
+	enc   *imgcat.Encoder
+	paths []string
+	// Resize receives a value when the terminal is resized, such as
+	// a SIGWINCH signal.
+	Resize <-chan os.Signal
+	// Quit receives a value when the viewer should quit, such as an
+	// interrupt signal.
+	Quit <-chan os.Signal
+
+	state
+	win    imgcat.Window
+	img    *Image
+	loaded int // index of img, -1 if none.
+	err    error
+	dirty  bool
+
+	// Can be swapped for testing.
+	load func(path string) (*Image, error)
+}
+
+// New returns a viewer of the image files with the given paths, which
+// draws in the terminal with the encoder. The encoder should write to the
+// terminal.
+func New(term Terminal, enc *imgcat.Encoder, paths []string) *Viewer {
+	return &Viewer{
+		term:   term,
+		enc:    enc,
+		paths:  paths,
+		state:  state{zoom: 1, x: 0.5, y: 0.5},
+		loaded: -1,
+		load:   Load,
+	}
+}
+
+// Run shows the images in the alternate screen until the user quits,
+// Quit receives a value, or reading from the terminal fails, restoring the screen before returning.
+// The end of the input is the same as quitting.
+func (v *Viewer) Run() error {
+	if len(v.paths) == 0 {
+		return fmt.Errorf("no images to show")
+	}
+	if _, err := io.WriteString(v.term, enterScreen); err != nil {
+		return err
+	}
+	err := v.loop()
+	if _, lerr := io.WriteString(v.term, v.clear()+leaveScreen); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+func (v *Viewer) loop() error {
+	v.dirty = true
+	buf := make([]byte, 256)
+	for {
+		select {
+		case <-v.Resize:
+			v.dirty = true
+		case <-v.Quit:
+			return nil
+		default:
+		}
+		if v.dirty {
+			if err := v.draw(); err != nil {
+				return err
+			}
+		}
+
+		n, err := v.term.Read(buf)
+		for _, k := range parseKeys(buf[:n]) {
+			if !v.handle(k) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handle updates the state of the viewer after a key press. It returns
+// false if the viewer should quit.
+func (v *Viewer) handle(key string) bool {
+	old := v.state
+	switch key {
+	case "q", keyEscape, keyCtrlC:
+		return false
+	case "n", " ", keyEnter, keyPageDown:
+		v.show(v.index + 1)
+	case "p", keyBackspace, keyPageUp:
+		v.show(v.index - 1)
+	case keyHome:
+		v.show(0)
+	case keyEnd:
+		v.show(len(v.paths) - 1)
+	case "+", "=":
+		v.setZoom(v.zoom * zoomStep)
+	case "-":
+		v.setZoom(v.zoom / zoomStep)
+	case "f", "0":
+		v.setZoom(1)
+	case "1":
+		if img, err := v.image(); err == nil {
+			v.setZoom(1 / v.fit(img.Bounds()))
+		}
+	case keyLeft, "h":
+		v.pan(-1, 0)
+	case keyRight, "l":
+		v.pan(1, 0)
+	case keyUp, "k":
+		v.pan(0, -1)
+	case keyDown, "j":
+		v.pan(0, 1)
+	case "i":
+		v.info = !v.info
+	case "r", keyCtrlL:
+		v.dirty = true
+	}
+	if v.state != old {
+		v.dirty = true
+	}
+	return true
+}
+
+// show goes to the image with the given index, wrapping around the ends,
+// fit to the screen.
+func (v *Viewer) show(i int) {
+	n := len(v.paths)
+	v.index = (i%n + n) % n
+	v.zoom, v.x, v.y = 1, 0.5, 0.5
+}
+
+// setZoom changes the zoom, keeping the center of the view.
+func (v *Viewer) setZoom(z float64) {
+	img, err := v.image()
+	if err != nil {
+		return
+	}
+	v.zoom = math.Max(1, math.Min(z, maxScale/v.fit(img.Bounds())))
+	v.clampView(img.Bounds())
+}
+
+// pan moves the view a quarter of its size in the given direction.
+func (v *Viewer) pan(dx, dy int) {
+	img, err := v.image()
+	if err != nil {
+		return
+	}
+	b := img.Bounds()
+	src, _, _ := v.layout(b)
+	v.x += float64(dx*src.Dx()) / 4 / float64(b.Dx())
+	v.y += float64(dy*src.Dy()) / 4 / float64(b.Dy())
+	v.clampView(b)
+}
+
+// clampView moves the center of the view so it shows no space around an
+// image with the given bounds.
+func (v *Viewer) clampView(b image.Rectangle) {
+	src, _, _ := v.layout(b)
+	src = src.Sub(b.Min)
+	v.x = (float64(src.Min.X) + float64(src.Dx())/2) / float64(b.Dx())
+	v.y = (float64(src.Min.Y) + float64(src.Dy())/2) / float64(b.Dy())
+}
+
+// area returns the size in pixels of the part of the screen where images
+// are drawn, all but the status line, and the size of a cell.
+func (v *Viewer) area() (w, h, cw, ch int) {
+	cw, ch = v.win.Cell()
+	if cw <= 0 || ch <= 0 {
+		cw, ch = defaultCellWidth, defaultCellHeight
+	}
+	return v.win.Columns * cw, (v.win.Rows - 1) * ch, cw, ch
+}
+
+// fit returns the scale that fits an image with the given bounds in the
+// screen. Small images are not enlarged.
+func (v *Viewer) fit(b image.Rectangle) float64 {
+	w, h, _, _ := v.area()
+	s := math.Min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	return math.Max(math.Min(1, s), 1e-6)
+}
+
+// layout returns the region of the image with the given bounds that is
+// shown, and the size in pixels it is drawn at.
+func (v *Viewer) layout(b image.Rectangle) (src image.Rectangle, w, h int) {
+	aw, ah, _, _ := v.area()
+	scale := v.fit(b) * v.zoom
+	sw := clamp(int(math.Round(float64(aw)/scale)), 1, b.Dx())
+	sh := clamp(int(math.Round(float64(ah)/scale)), 1, b.Dy())
+	x := clamp(int(math.Round(v.x*float64(b.Dx())-float64(sw)/2)), 0, b.Dx()-sw)
+	y := clamp(int(math.Round(v.y*float64(b.Dy())-float64(sh)/2)), 0, b.Dy()-sh)
+	src = image.Rect(x, y, x+sw, y+sh).Add(b.Min)
+	w = clamp(int(math.Round(float64(sw)*scale)), 1, aw)
+	h = clamp(int(math.Round(float64(sh)*scale)), 1, ah)
+	return src, w, h
+}
+
+// image returns the current image, loading it if needed.
+func (v *Viewer) image() (*Image, error) {
+	if v.loaded != v.index {
+		v.img, v.err = v.load(v.paths[v.index])
+		v.loaded = v.index
+	}
+	return v.img, v.err
+}
+
+// clear returns the escape sequences that clear the screen, and the
+// images on it.
+func (v *Viewer) clear() string {
+	if v.enc.Protocol() == imgcat.Kitty {
+		return kittyDelete + clearScreen
+	}
+	return clearScreen
+}
+
+// draw clears the screen and draws the current image in the middle of
+// it, the information overlay, and the status line.
+func (v *Viewer) draw() error {
+	v.dirty = false
+	win, err := v.term.Size()
+	if err != nil {
+		return fmt.Errorf("could not get terminal size: %v", err)
+	}
+	v.win = win
+	if _, err := io.WriteString(v.term, v.clear()); err != nil {
+		return err
+	}
+	_, _, cw, ch := v.area()
+	if v.win.Columns < 1 || v.win.Rows < 2 {
+		return nil
+	}
+
+	img, err := v.image()
+	if err != nil {
+		status := err.Error()
+		if len(v.paths) > 1 {
+			status = fmt.Sprintf("%s  %d/%d", status, v.index+1, len(v.paths))
+		}
+		return v.drawStatus(status)
+	}
+
+	b := img.Bounds()
+	src, w, h := v.layout(b)
+	cols, rows := (w+cw-1)/cw, (h+ch-1)/ch
+	top, left := 1+(v.win.Rows-1-rows)/2, 1+(v.win.Columns-cols)/2
+	m := imgcat.Resize(crop(img, src), w, h, imgcat.CatmullRom)
+	options := []imgcat.Option{
+		imgcat.Width(imgcat.Cells(cols)), imgcat.Height(imgcat.Cells(rows)), imgcat.CellSize(cw, ch),
+	}
+	// The placement positions every line of images drawn as text, and
+	// doesn't scroll the screen.
+	if _, err := v.enc.Place(m, top, left, options...); err != nil {
+		return err
+	}
+	if v.info {
+		if err := v.drawInfo(img); err != nil {
+			return err
+		}
+	}
+	zoom := int(math.Round(100 * v.fit(b) * v.zoom))
+	return v.drawStatus(fmt.Sprintf("%s  %d/%d  %d%%", img.Name, v.index+1, len(v.paths), zoom))
+}
+
+// drawStatus draws the status line, at the bottom of the screen, followed
+// by a reminder of the keys.
+func (v *Viewer) drawStatus(status string) error {
+	return v.drawLine(v.win.Rows, status+"  (q quit, n/p next/previous, +/- zoom, arrows pan, f fit, i info)")
+}
+
+// drawInfo draws the format, size, and file size of the image in the top
+// left corner of the screen.
+func (v *Viewer) drawInfo(img *Image) error {
+	b := img.Bounds()
+	lines := []string{
+		img.Name,
+		fmt.Sprintf("%s %dx%d", strings.ToUpper(img.Format), b.Dx(), b.Dy()),
+		formatBytes(img.Bytes),
+	}
+	for i, l := range lines {
+		if err := v.drawLine(i+1, "\x1b[7m "+truncate(l, v.win.Columns-2)+" \x1b[0m"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawLine writes text at the start of the given row, erasing it first
+// if it's the status line.
+func (v *Viewer) drawLine(row int, text string) error {
+	erase := ""
+	if row == v.win.Rows {
+		erase = "\x1b[2K"
+		text = truncate(text, v.win.Columns)
+	}
+	_, err := fmt.Fprintf(v.term, "\x1b[%d;1H%s%s", row, erase, text)
+	return err
+}
+
+// crop returns the region of m, sharing its pixels if possible.
+func crop(m image.Image, r image.Rectangle) image.Image {
+	if m.Bounds() == r {
+		return m
+	}
+	if s, ok := m.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dst.Set(x-r.Min.X, y-r.Min.Y, m.At(x, y))
+		}
+	}
+	return dst
+}
+
+// formatBytes returns a size in bytes in a human readable form.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	f, i := float64(n)/unit, 0
+	for ; f >= unit && i < 3; i++ {
+		f /= unit
+	}
+	return fmt.Sprintf("%.1f %ciB", f, "KMGT"[i])
+}
+
+// truncate returns s cut to n characters.
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		if n < 0 {
+			n = 0
+		}
+		return string(r[:n])
+	}
+	return s
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
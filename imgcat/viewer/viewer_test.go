@@ -0,0 +1,343 @@
+package viewer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+func TestParseKeys(t *testing.T) {
+	tc := []struct {
+		in   string
+		keys []string
+	}{
+		{"", nil},
+		{"q", []string{"q"}},
+		{"nn+", []string{"n", "n", "+"}},
+		{"\x1b[A\x1b[B\x1bOC\x1b[D", []string{keyUp, keyDown, keyRight, keyLeft}},
+		{"\x1b[5~\x1b[6~\x1b[H\x1b[4~", []string{keyPageUp, keyPageDown, keyHome, keyEnd}},
+		{"\x1b", []string{keyEscape}},
+		{"\x1b[1;5Cx", []string{"x"}},
+		{"\x03\x0c\x7f\r", []string{keyCtrlC, keyCtrlL, keyBackspace, keyEnter}},
+		{"é ", []string{"é", " "}},
+	}
+	for _, tt := range tc {
+		if got := parseKeys([]byte(tt.in)); !reflect.DeepEqual(got, tt.keys) {
+			t.Errorf("parseKeys(%q) = %q; want %q", tt.in, got, tt.keys)
+		}
+	}
+}
+
+// fakeTerminal returns the given reads, one per call, and then io.EOF.
+type fakeTerminal struct {
+	bytes.Buffer
+	win   imgcat.Window
+	reads []string
+	// before is called before each read with the number of reads so far.
+	before func(n int)
+	n      int
+	sizes  int
+}
+
+func (t *fakeTerminal) Read(p []byte) (int, error) {
+	if t.before != nil {
+		t.before(t.n)
+	}
+	if t.n >= len(t.reads) {
+		return 0, io.EOF
+	}
+	t.n++
+	return copy(p, t.reads[t.n-1]), nil
+}
+
+func (t *fakeTerminal) Size() (imgcat.Window, error) {
+	t.sizes++
+	return t.win, nil
+}
+
+// A window of 80x25 cells, of 8x16 pixels each.
+var testWindow = imgcat.Window{Columns: 80, Rows: 25, Width: 640, Height: 400}
+
+// newTestViewer returns a viewer of images of the given sizes, named
+// after their index, in a fake terminal with the test window.
+func newTestViewer(t *testing.T, p imgcat.Protocol, sizes ...image.Point) (*Viewer, *fakeTerminal) {
+	term := &fakeTerminal{win: testWindow}
+	enc, err := imgcat.NewProtocolEncoder(term, p, imgcat.Inline(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i := range sizes {
+		paths = append(paths, fmt.Sprintf("%d.png", i))
+	}
+	v := New(term, enc, paths)
+	v.load = func(path string) (*Image, error) {
+		var i int
+		if _, err := fmt.Sscanf(path, "%d.png", &i); err != nil || sizes[i] == (image.Point{}) {
+			return nil, fmt.Errorf("could not decode %s", path)
+		}
+		m := image.NewNRGBA(image.Rectangle{Max: sizes[i]})
+		return &Image{Image: m, Name: path, Format: "png", Bytes: 1536}, nil
+	}
+	v.win = testWindow
+	return v, term
+}
+
+func TestLayout(t *testing.T) {
+	v, _ := newTestViewer(t, imgcat.ITerm2, image.Pt(1280, 384), image.Pt(100, 50))
+
+	steps := []struct {
+		keys string
+		src  image.Rectangle
+		w, h int
+	}{
+		// The screen is 640x384 pixels without the status line.
+		{"", image.Rect(0, 0, 1280, 384), 640, 192},
+		{"+", image.Rect(214, 0, 1067, 384), 640, 288},
+		{"=", image.Rect(356, 22, 925, 363), 640, 384},
+		{"-", image.Rect(214, 0, 1067, 384), 640, 288},
+		{"1", image.Rect(321, 0, 961, 384), 640, 384},
+		{"\x1b[C", image.Rect(481, 0, 1121, 384), 640, 384},
+		{"llll", image.Rect(640, 0, 1280, 384), 640, 384},
+		{"hhhhhhhh", image.Rect(0, 0, 640, 384), 640, 384},
+		{"kj", image.Rect(0, 0, 640, 384), 640, 384},
+		{"f", image.Rect(0, 0, 1280, 384), 640, 192},
+		{"-", image.Rect(0, 0, 1280, 384), 640, 192},
+		// Small images are not enlarged to fit.
+		{"n", image.Rect(0, 0, 100, 50), 100, 50},
+		// Zooming in stops at 16 screen pixels per image pixel.
+		{"++++++++++++", image.Rect(30, 13, 70, 37), 640, 384},
+	}
+	for _, s := range steps {
+		for _, k := range parseKeys([]byte(s.keys)) {
+			v.handle(k)
+		}
+		img, err := v.image()
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, w, h := v.layout(img.Bounds())
+		if src != s.src || w != s.w || h != s.h {
+			t.Errorf("after %q: showing %v at %dx%d; want %v at %dx%d", s.keys, src, w, h, s.src, s.w, s.h)
+		}
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	v, _ := newTestViewer(t, imgcat.ITerm2, image.Pt(10, 10), image.Pt(10, 10), image.Pt(10, 10))
+	steps := []struct {
+		key   string
+		index int
+	}{
+		{"n", 1}, {" ", 2}, {keyEnter, 0}, {"p", 2}, {keyBackspace, 1},
+		{keyPageDown, 2}, {keyPageUp, 1}, {keyHome, 0}, {keyEnd, 2},
+	}
+	for _, s := range steps {
+		if !v.handle(s.key) {
+			t.Fatalf("%q quit the viewer", s.key)
+		}
+		if v.index != s.index {
+			t.Errorf("after %q at image %d; want %d", s.key, v.index, s.index)
+		}
+	}
+
+	v.handle("+")
+	v.handle("i")
+	v.handle("n")
+	if v.zoom != 1 || !v.info {
+		t.Errorf("going to the next image should reset the zoom and keep the overlay")
+	}
+
+	for _, k := range []string{"q", keyEscape, keyCtrlC} {
+		if v.handle(k) {
+			t.Errorf("%q should quit the viewer", k)
+		}
+	}
+}
+
+// images decodes the images written to the terminal, and returns them with
+// the text around them.
+func images(t *testing.T, out string) ([]*imgcat.File, string) {
+	var text bytes.Buffer
+	dec := imgcat.NewDecoder(strings.NewReader(out), &text)
+	var files []*imgcat.File
+	for {
+		f, err := dec.Next()
+		if err == io.EOF {
+			return files, text.String()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+}
+
+func TestRun(t *testing.T) {
+	defer func() { os.Unsetenv("TMUX_TEST") }()
+	os.Setenv("TMUX_TEST", "false")
+
+	v, term := newTestViewer(t, imgcat.ITerm2, image.Pt(64, 32), image.Pt(1280, 384))
+	term.reads = []string{"i", "x", "n", "q", "n"}
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	files, text := images(t, term.String())
+
+	if !strings.HasPrefix(text, enterScreen) || !strings.HasSuffix(text, leaveScreen) {
+		t.Errorf("the viewer should use the alternate screen, got %q", text)
+	}
+	// Unknown keys don't redraw, and keys after quitting are ignored.
+	if len(files) != 3 {
+		t.Fatalf("drew %d images; want 3", len(files))
+	}
+	sizes := []string{"8 2", "8 2", "80 12"}
+	for i, f := range files {
+		if got := fmt.Sprintf("%s %s", f.Width, f.Height); got != sizes[i] {
+			t.Errorf("image %d drawn at %s; want %s", i, got, sizes[i])
+		}
+	}
+	for _, s := range []string{
+		// The first image is centered.
+		"\x1b[12;37H",
+		"0.png  1/2  100%",
+		"PNG 64x32",
+		"1.5 KiB",
+		"1.png  2/2  50%",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("missing %q in %q", s, text)
+		}
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	v, term := newTestViewer(t, imgcat.ITerm2, image.Point{}, image.Pt(10, 10))
+	term.reads = []string{"+", "l", "1", "n"}
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	files, text := images(t, term.String())
+	if len(files) != 1 {
+		t.Errorf("drew %d images; want 1", len(files))
+	}
+	if !strings.Contains(text, "could not decode 0.png  1/2") {
+		t.Errorf("missing the error in %q", text)
+	}
+}
+
+func TestResize(t *testing.T) {
+	v, term := newTestViewer(t, imgcat.ITerm2, image.Pt(1280, 384))
+	resize := make(chan os.Signal, 1)
+	v.Resize = resize
+	term.reads = []string{"", ""}
+	term.before = func(n int) {
+		if n == 1 {
+			term.win = imgcat.Window{Columns: 40, Rows: 13, Width: 320, Height: 208}
+			resize <- os.Interrupt // any signal, SIGWINCH isn't portable.
+		}
+	}
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := images(t, term.String())
+	if len(files) != 2 || term.sizes != 2 {
+		t.Fatalf("drew %d images and got the size %d times; want 2 and 2", len(files), term.sizes)
+	}
+	if got := fmt.Sprintf("%s %s", files[1].Width, files[1].Height); got != "40 6" {
+		t.Errorf("image drawn at %s after resize; want 40 6", got)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	v, term := newTestViewer(t, imgcat.ITerm2, image.Pt(10, 10))
+	quit := make(chan os.Signal, 1)
+	v.Quit = quit
+	term.reads = []string{"", "n", "n"}
+	term.before = func(n int) {
+		if n == 1 {
+			quit <- os.Interrupt
+		}
+	}
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if term.n != 2 {
+		t.Errorf("read %d times from the terminal; want 2", term.n)
+	}
+	if !strings.HasSuffix(term.String(), leaveScreen) {
+		t.Errorf("the screen should be restored when quitting")
+	}
+}
+
+func TestKittyClear(t *testing.T) {
+	v, term := newTestViewer(t, imgcat.Kitty, image.Pt(10, 10))
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(term.String(), kittyDelete); n != 2 {
+		t.Errorf("deleted kitty images %d times; want 2, when drawing and leaving", n)
+	}
+}
+
+func TestBlocksPosition(t *testing.T) {
+	v, term := newTestViewer(t, imgcat.Blocks, image.Pt(64, 64))
+	if err := v.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// Every line of the image starts at the column of the centered image.
+	out := term.String()
+	for row := 11; row <= 14; row++ {
+		if s := fmt.Sprintf("\x1b[%d;37H", row); !strings.Contains(out, s) {
+			t.Errorf("line at row %d not positioned in %q", row, out)
+		}
+	}
+}
+
+func TestNoImages(t *testing.T) {
+	v, _ := newTestViewer(t, imgcat.ITerm2)
+	if err := v.Run(); err == nil {
+		t.Errorf("expected error running with no images")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	img, err := Load("../testdata/icon.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat("../testdata/icon.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Name != "icon.png" || img.Format != "jpeg" || img.Bytes != fi.Size() {
+		t.Errorf("loaded %s, %s, %d bytes; want icon.png, jpeg, %d bytes", img.Name, img.Format, img.Bytes, fi.Size())
+	}
+	if _, err := Load("../testdata/icon.sixel"); err == nil {
+		t.Errorf("expected error loading a sixel file")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tc := map[int64]string{
+		0:            "0 B",
+		1023:         "1023 B",
+		1024:         "1.0 KiB",
+		1536:         "1.5 KiB",
+		5 << 20:      "5.0 MiB",
+		3 << 30:      "3.0 GiB",
+		1<<50 + 1000: "1024.0 TiB",
+	}
+	for n, want := range tc {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%d) = %q; want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plot
+
+import (
+	"math"
+	"strconv"
+)
+
+// An axis maps values in [lo, hi] to positions, with labeled ticks.
+type axis struct {
+	lo, hi float64
+	ticks  []float64
+	labels []string
+}
+
+// pos returns the position of v in an axis n units long.
+func (a axis) pos(v float64, n int) float64 {
+	return (v - a.lo) / (a.hi - a.lo) * float64(n-1)
+}
+
+// niceAxis returns an axis covering [lo, hi] with about n ticks at round
+// values, extending the range to the first and last ticks. There are
+// always at least two ticks, so small plots still have a range.
+func niceAxis(lo, hi float64, n int) axis {
+	if n < 2 {
+		n = 2
+	}
+	if lo == hi {
+		d := math.Abs(lo) / 10
+		if d == 0 {
+			d = 1
+		}
+		lo, hi = lo-d, hi+d
+	}
+	step := niceNum((hi - lo) / float64(n-1))
+	first, last := math.Floor(lo/step), math.Ceil(hi/step)
+	a := axis{lo: first * step, hi: last * step}
+	decimals := 0
+	if e := -int(math.Floor(math.Log10(step))); e > 0 {
+		decimals = e
+	}
+	for i := first; i <= last; i++ {
+		v := i * step
+		a.ticks = append(a.ticks, v)
+		label := strconv.FormatFloat(v, 'f', decimals, 64)
+		if v == 0 {
+			// Avoid -0.
+			label = strconv.FormatFloat(0, 'f', decimals, 64)
+		}
+		a.labels = append(a.labels, label)
+	}
+	return a
+}
+
+// niceNum returns a number close to x that is 1, 2, or 5 times a power of 10.
+func niceNum(x float64) float64 {
+	exp := math.Pow(10, math.Floor(math.Log10(x)))
+	switch f := x / exp; {
+	case f < 1.5:
+		return exp
+	case f < 3:
+		return 2 * exp
+	case f < 7:
+		return 5 * exp
+	default:
+		return 10 * exp
+	}
+}
+
+// categoryAxis returns an axis with a tick for each of the given labels.
+func categoryAxis(labels []string) axis {
+	a := axis{lo: -0.5, hi: float64(len(labels)) - 0.5, labels: labels}
+	for i := range labels {
+		a.ticks = append(a.ticks, float64(i))
+	}
+	return a
+}
@@ -0,0 +1,491 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plot draws line charts, bar charts, histograms, and scatter plots
+// as images, to be shown in the terminal with imgcat or as text when the
+// terminal doesn't support graphics.
+package plot
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+
+	"github.com/campoy/tools/imgcat"
+)
+
+// Default size of the images in pixels.
+const (
+	defaultWidth  = 640
+	defaultHeight = 400
+)
+
+// Colors used for series with no color, in order.
+var palette = []color.Color{
+	color.RGBA{0x1f, 0x77, 0xb4, 0xff},
+	color.RGBA{0xff, 0x7f, 0x0e, 0xff},
+	color.RGBA{0x2c, 0xa0, 0x2c, 0xff},
+	color.RGBA{0xd6, 0x27, 0x28, 0xff},
+	color.RGBA{0x94, 0x67, 0xbd, 0xff},
+	color.RGBA{0x8c, 0x56, 0x4b, 0xff},
+}
+
+type kind int
+
+const (
+	line kind = iota
+	scatter
+	bars
+)
+
+// A series is a set of points drawn in the same way. Bars are centered
+// at their x values and are width wide.
+type series struct {
+	kind   kind
+	name   string
+	xs, ys []float64
+	width  float64
+	color  color.Color
+}
+
+// A Plot draws series of data over shared axes.
+// The zero value is an empty plot of the default size.
+type Plot struct {
+	Title          string
+	XLabel, YLabel string
+	// Width and Height of the image in pixels. Defaults to 640x400.
+	Width, Height int
+	// Background and Foreground colors of the image, the later is used
+	// for the axes and texts. Default to white and black.
+	Background, Foreground color.Color
+
+	series []series
+	// categories labeling the x axis of bar charts.
+	categories []string
+}
+
+// Line adds a line joining the points with the given coordinates.
+// A nil color picks one from the default palette.
+func (p *Plot) Line(name string, xs, ys []float64, c color.Color) {
+	p.add(series{kind: line, name: name, xs: xs, ys: ys, color: c})
+}
+
+// Scatter adds a marker at each of the points with the given coordinates.
+// A nil color picks one from the default palette.
+func (p *Plot) Scatter(name string, xs, ys []float64, c color.Color) {
+	p.add(series{kind: scatter, name: name, xs: xs, ys: ys, color: c})
+}
+
+// Bars adds a bar for each value, labeled with the category at the same
+// index. All bar charts of a plot share their categories, and their bars
+// are drawn next to each other. A nil color picks one from the default
+// palette.
+func (p *Plot) Bars(name string, categories []string, values []float64, c color.Color) {
+	if len(categories) > len(p.categories) {
+		p.categories = categories
+	}
+	xs := make([]float64, len(values))
+	for i := range xs {
+		xs[i] = float64(i)
+	}
+	p.add(series{kind: bars, name: name, xs: xs, ys: values, width: 0.8, color: c})
+}
+
+// Histogram adds bars counting how many values fall in each of the given
+// number of bins, evenly splitting the range of the values. Zero bins
+// uses the square root of the number of values. Values that are not
+// finite, such as NaN, are not counted.
+// A nil color picks one from the default palette.
+func (p *Plot) Histogram(name string, values []float64, bins int, c color.Color) {
+	var finite []float64
+	for _, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			finite = append(finite, v)
+		}
+	}
+	values = finite
+	if bins <= 0 {
+		bins = int(math.Ceil(math.Sqrt(float64(len(values)))))
+	}
+	if bins == 0 {
+		bins = 1
+	}
+	lo, hi := bounds(values)
+	if lo == hi {
+		hi = lo + 1
+	}
+	width := (hi - lo) / float64(bins)
+	xs, ys := make([]float64, bins), make([]float64, bins)
+	for i := range xs {
+		xs[i] = lo + (float64(i)+0.5)*width
+	}
+	for _, v := range values {
+		i := int((v - lo) / width)
+		if i >= bins {
+			i = bins - 1
+		}
+		ys[i]++
+	}
+	p.add(series{kind: bars, name: name, xs: xs, ys: ys, width: width, color: c})
+}
+
+func (p *Plot) add(s series) {
+	if s.color == nil {
+		s.color = palette[len(p.series)%len(palette)]
+	}
+	p.series = append(p.series, s)
+}
+
+func bounds(vs []float64) (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, v := range vs {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if len(vs) == 0 {
+		return 0, 0
+	}
+	return lo, hi
+}
+
+// axes returns the axes covering all the series, with about the given
+// number of ticks. Bar charts always include zero.
+func (p *Plot) axes(xticks, yticks int) (x, y axis, err error) {
+	if len(p.series) == 0 {
+		return x, y, fmt.Errorf("nothing to plot")
+	}
+	xlo, xhi := math.Inf(1), math.Inf(-1)
+	ylo, yhi := math.Inf(1), math.Inf(-1)
+	for _, s := range p.series {
+		if len(s.xs) != len(s.ys) {
+			return x, y, fmt.Errorf("series %q has %d x values and %d y values", s.name, len(s.xs), len(s.ys))
+		}
+		for i := range s.xs {
+			if math.IsNaN(s.xs[i]) || math.IsInf(s.xs[i], 0) || math.IsNaN(s.ys[i]) || math.IsInf(s.ys[i], 0) {
+				return x, y, fmt.Errorf("series %q has a non finite value", s.name)
+			}
+			xlo, xhi = math.Min(xlo, s.xs[i]-s.width/2), math.Max(xhi, s.xs[i]+s.width/2)
+			ylo, yhi = math.Min(ylo, s.ys[i]), math.Max(yhi, s.ys[i])
+		}
+		if s.kind == bars {
+			ylo, yhi = math.Min(ylo, 0), math.Max(yhi, 0)
+		}
+	}
+	if math.IsInf(xlo, 0) {
+		return x, y, fmt.Errorf("nothing to plot")
+	}
+	if p.categories != nil {
+		x = categoryAxis(p.categories)
+	} else {
+		x = niceAxis(xlo, xhi, xticks)
+	}
+	return x, niceAxis(ylo, yhi, yticks), nil
+}
+
+// A canvas is a surface where the series are drawn.
+type canvas interface {
+	size() (w, h int)
+	set(x, y int, c color.Color)
+}
+
+// draw draws the series in the canvas, with markers of the given radius.
+func (p *Plot) draw(cv canvas, x, y axis, radius int) {
+	w, h := cv.size()
+	px := func(v float64) int { return int(math.Round(x.pos(v, w))) }
+	py := func(v float64) int { return h - 1 - int(math.Round(y.pos(v, h))) }
+
+	// Bar charts sharing categories are drawn next to each other.
+	groups, group := 0, 0
+	for _, s := range p.series {
+		if s.kind == bars && p.categories != nil {
+			groups++
+		}
+	}
+
+	for _, s := range p.series {
+		switch s.kind {
+		case line:
+			for i := 1; i < len(s.xs); i++ {
+				drawLine(cv, px(s.xs[i-1]), py(s.ys[i-1]), px(s.xs[i]), py(s.ys[i]), s.color)
+			}
+		case scatter:
+			for i := range s.xs {
+				cx, cy := px(s.xs[i]), py(s.ys[i])
+				for d := -radius; d <= radius; d++ {
+					cv.set(cx+d, cy, s.color)
+					cv.set(cx, cy+d, s.color)
+				}
+			}
+		case bars:
+			width, offset := s.width, 0.0
+			if p.categories != nil {
+				width = s.width / float64(groups)
+				offset = -s.width/2 + (float64(group)+0.5)*width
+				group++
+			}
+			for i := range s.xs {
+				x0, x1 := px(s.xs[i]+offset-width/2), px(s.xs[i]+offset+width/2)
+				if x1-x0 > 2 {
+					// Leave a gap between adjacent bars.
+					x1--
+				}
+				y0, y1 := py(0), py(s.ys[i])
+				if y0 > y1 {
+					y0, y1 = y1, y0
+				}
+				for bx := x0; bx <= x1; bx++ {
+					for by := y0; by <= y1; by++ {
+						cv.set(bx, by, s.color)
+					}
+				}
+			}
+		}
+	}
+}
+
+// drawLine draws a line between two points with Bresenham's algorithm.
+func drawLine(cv canvas, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := sign(x1-x0), sign(y1-y0)
+	err := dx + dy
+	for {
+		cv.set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * err; e2 >= dy {
+			err += dy
+			x0 += sx
+		} else {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+// imageCanvas draws on the plot area of an image, clipping what's outside.
+type imageCanvas struct {
+	m    *image.NRGBA
+	area image.Rectangle
+	// thick lines are drawn two pixels wide.
+	thick bool
+}
+
+func (c *imageCanvas) size() (int, int) { return c.area.Dx(), c.area.Dy() }
+
+func (c *imageCanvas) set(x, y int, col color.Color) {
+	for _, d := range []image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}}[:c.dots()] {
+		pt := image.Pt(c.area.Min.X+x+d.X, c.area.Min.Y+y+d.Y)
+		if pt.In(c.area) {
+			c.m.Set(pt.X, pt.Y, col)
+		}
+	}
+}
+
+func (c *imageCanvas) dots() int {
+	if c.thick {
+		return 4
+	}
+	return 1
+}
+
+// Image draws the plot as an image.
+func (p *Plot) Image() (image.Image, error) {
+	w, h := p.Width, p.Height
+	if w <= 0 || h <= 0 {
+		w, h = defaultWidth, defaultHeight
+	}
+	bg, fg := p.Background, p.Foreground
+	if bg == nil {
+		bg = color.White
+	}
+	if fg == nil {
+		fg = color.Black
+	}
+	scale := 1 + h/800
+	charW, charH := (glyphWidth+glyphSpace)*scale, (glyphHeight+glyphSpace)*scale
+
+	x, y, err := p.axes(w/(12*charW), h/(4*charH))
+	if err != nil {
+		return nil, err
+	}
+
+	// Margins around the plot area for the texts.
+	top, bottom, right := charH, 2*charH+4*scale, 2*charW
+	if n := len(x.labels); n > 0 {
+		right = max(right, textWidth(x.labels[n-1], scale)/2+charW)
+	}
+	if p.Title != "" {
+		top += 2 * charH
+	}
+	if p.YLabel != "" {
+		top += 2 * charH
+	}
+	if p.XLabel != "" {
+		bottom += charH
+	}
+	left := 0
+	for _, l := range y.labels {
+		if n := textWidth(l, scale); n > left {
+			left = n
+		}
+	}
+	left += charW
+	area := image.Rect(left, top, w-right, h-bottom)
+	if area.Dx() < 10 || area.Dy() < 10 {
+		return nil, fmt.Errorf("image of %dx%d pixels is too small", w, h)
+	}
+
+	m := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	grid := color.NRGBAModel.Convert(fg).(color.NRGBA)
+	grid.A /= 8
+
+	// Grid lines and tick labels.
+	for i, v := range y.ticks {
+		py := area.Max.Y - 1 - int(math.Round(y.pos(v, area.Dy())))
+		draw.Draw(m, image.Rect(area.Min.X, py, area.Max.X, py+1), image.NewUniform(grid), image.Point{}, draw.Over)
+		l := y.labels[i]
+		drawText(m, image.Pt(area.Min.X-charW/2-textWidth(l, scale), py-glyphHeight*scale/2), l, fg, scale)
+	}
+	for i, v := range x.ticks {
+		px := area.Min.X + int(math.Round(x.pos(v, area.Dx())))
+		draw.Draw(m, image.Rect(px, area.Min.Y, px+1, area.Max.Y), image.NewUniform(grid), image.Point{}, draw.Over)
+		draw.Draw(m, image.Rect(px, area.Max.Y, px+1, area.Max.Y+3*scale), image.NewUniform(fg), image.Point{}, draw.Src)
+		if i < len(x.labels) {
+			l := x.labels[i]
+			drawText(m, image.Pt(px-textWidth(l, scale)/2, area.Max.Y+4*scale), l, fg, scale)
+		}
+	}
+	// Axes.
+	draw.Draw(m, image.Rect(area.Min.X-1, area.Min.Y, area.Min.X, area.Max.Y+1), image.NewUniform(fg), image.Point{}, draw.Src)
+	draw.Draw(m, image.Rect(area.Min.X-1, area.Max.Y, area.Max.X, area.Max.Y+1), image.NewUniform(fg), image.Point{}, draw.Src)
+
+	// Titles.
+	if p.Title != "" {
+		drawText(m, image.Pt((w-textWidth(p.Title, scale))/2, charH/2), p.Title, fg, scale)
+	}
+	if p.YLabel != "" {
+		drawText(m, image.Pt(charW/2, area.Min.Y-2*charH), p.YLabel, fg, scale)
+	}
+	if p.XLabel != "" {
+		drawText(m, image.Pt(area.Min.X+(area.Dx()-textWidth(p.XLabel, scale))/2, h-charH), p.XLabel, fg, scale)
+	}
+
+	p.draw(&imageCanvas{m: m, area: area, thick: scale > 1 || w >= defaultWidth}, x, y, 2*scale)
+	p.legend(m, area, fg, bg, scale)
+	return m, nil
+}
+
+// legend lists the names of the series in the top right corner of the
+// plot area, if there's more than one.
+func (p *Plot) legend(m *image.NRGBA, area image.Rectangle, fg, bg color.Color, scale int) {
+	var named []series
+	for _, s := range p.series {
+		if s.name != "" {
+			named = append(named, s)
+		}
+	}
+	if len(named) < 2 {
+		return
+	}
+	charW, charH := (glyphWidth+glyphSpace)*scale, (glyphHeight+glyphSpace)*scale
+	width := 0
+	for _, s := range named {
+		if n := textWidth(s.name, scale); n > width {
+			width = n
+		}
+	}
+	x, y := area.Max.X-width-3*charW, area.Min.Y+charH/2
+	box := image.Rect(x, y, x+charW+glyphHeight*scale+width, y+len(named)*(charH+2*scale)).Inset(-2 * scale)
+	draw.Draw(m, box, image.NewUniform(bg), image.Point{}, draw.Src)
+	for i, s := range named {
+		y := y + i*(charH+2*scale)
+		draw.Draw(m, image.Rect(x, y, x+glyphHeight*scale, y+glyphHeight*scale), image.NewUniform(s.color), image.Point{}, draw.Src)
+		drawText(m, image.Pt(x+charW+glyphHeight*scale, y), s.name, fg, scale)
+	}
+}
+
+// Encode draws the plot as an image and encodes it with the encoder.
+func (p *Plot) Encode(enc *imgcat.Encoder) error {
+	m, err := p.Image()
+	if err != nil {
+		return err
+	}
+	return enc.EncodeImage(m)
+}
+
+// Can be swapped for testing.
+var (
+	isSupported = imgcat.IsSupported
+	newEncoder  = func(w io.Writer) (*imgcat.Encoder, error) { return imgcat.NewEncoder(w, imgcat.Inline(true)) }
+)
+
+// Show writes the plot to w, which should be the terminal: as an image
+// if the terminal supports graphics and as text filling its width
+// otherwise.
+func (p *Plot) Show(w io.Writer) error {
+	if isSupported() {
+		enc, err := newEncoder(w)
+		if err != nil {
+			return err
+		}
+		return p.Encode(enc)
+	}
+	cols, rows := 80, 24
+	if f, ok := w.(*os.File); ok {
+		if win, err := imgcat.WindowSize(f); err == nil && win.Columns > 0 && win.Rows > 0 {
+			cols, rows = win.Columns, win.Rows
+		}
+	}
+	text, err := p.Text(cols, min(rows-1, cols/3))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, text)
+	return err
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func sign(a int) int {
+	switch {
+	case a < 0:
+		return -1
+	case a > 0:
+		return 1
+	}
+	return 0
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
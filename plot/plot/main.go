@@ -0,0 +1,137 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/campoy/tools/flags"
+	"github.com/campoy/tools/plot"
+	"github.com/pkg/errors"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	kind   = flag.String("kind", "line", "kind of plot: line, scatter, bar, or hist")
+	bins   = flag.Int("bins", 0, "number of bins of histograms, 0 uses the square root of the number of values")
+	title  = flag.String("title", "", "title of the plot")
+	xlabel = flag.String("xlabel", "", "label of the x axis")
+	ylabel = flag.String("ylabel", "", "label of the y axis")
+	width  = flag.Int("width", 640, "width of the image in pixels")
+	height = flag.Int("height", 400, "height of the image in pixels")
+	fg     = flags.HexColor("color", color.RGBA{0x1f, 0x77, 0xb4, 0xff}, "color of the data, as hex digits")
+	bg     = flags.HexColor("bg", color.White, "color of the background, as hex digits")
+	axes   = flags.HexColor("fg", color.Black, "color of the axes and texts, as hex digits")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage:\n\t%s [flags] [data_path]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Reads the data from the standard input if no path is given, one point per line:\n")
+		fmt.Fprintf(os.Stderr, "a y value, an x and a y value, or a label and a y value for bar charts.\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	in := io.Reader(os.Stdin)
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			return errors.Wrapf(err, "could not open %s", flag.Arg(0))
+		}
+		defer f.Close()
+		in = f
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	labels, xs, ys, err := read(in)
+	if err != nil {
+		return err
+	}
+
+	p := &plot.Plot{
+		Title:      *title,
+		XLabel:     *xlabel,
+		YLabel:     *ylabel,
+		Width:      *width,
+		Height:     *height,
+		Background: bg,
+		Foreground: axes,
+	}
+	switch *kind {
+	case "line":
+		p.Line("", xs, ys, fg)
+	case "scatter":
+		p.Scatter("", xs, ys, fg)
+	case "bar":
+		p.Bars("", labels, ys, fg)
+	case "hist":
+		p.Histogram("", ys, *bins, fg)
+	default:
+		return errors.Errorf("unknown kind of plot %q", *kind)
+	}
+	return errors.Wrap(p.Show(os.Stdout), "could not show plot")
+}
+
+// read parses lines with a y value, or an x or label and a y value.
+// Missing x values are the index of the line, and missing labels the x
+// value.
+func read(r io.Reader) (labels []string, xs, ys []float64, err error) {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, nil, nil, errors.Errorf("line %d: expected one or two values, got %d", n, len(fields))
+		}
+		y, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "line %d", n)
+		}
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			return nil, nil, nil, errors.Errorf("line %d: %s is not a finite number", n, fields[len(fields)-1])
+		}
+		label, x := strconv.Itoa(len(ys)), float64(len(ys))
+		if len(fields) == 2 {
+			label = fields[0]
+			if x, err = strconv.ParseFloat(label, 64); err != nil && *kind != "bar" {
+				return nil, nil, nil, errors.Wrapf(err, "line %d", n)
+			}
+		}
+		labels, xs, ys = append(labels, label), append(xs, x), append(ys, y)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "could not read data")
+	}
+	return labels, xs, ys, nil
+}
@@ -0,0 +1,225 @@
+package plot
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/campoy/tools/imgcat"
+	"github.com/campoy/tools/imgcat/imgtest"
+)
+
+func TestNiceAxis(t *testing.T) {
+	tc := []struct {
+		lo, hi float64
+		n      int
+		labels []string
+	}{
+		{0, 10, 6, []string{"0", "2", "4", "6", "8", "10"}},
+		{1, 9, 5, []string{"0", "2", "4", "6", "8", "10"}},
+		{-1, 1, 5, []string{"-1.0", "-0.5", "0.0", "0.5", "1.0"}},
+		{0.01, 0.04, 4, []string{"0.01", "0.02", "0.03", "0.04"}},
+		{5, 5, 3, []string{"4.5", "5.0", "5.5"}},
+		{0, 0, 3, []string{"-1", "0", "1"}},
+		{-130, 870, 5, []string{"-200", "0", "200", "400", "600", "800", "1000"}},
+	}
+	for _, tt := range tc {
+		a := niceAxis(tt.lo, tt.hi, tt.n)
+		if !reflect.DeepEqual(a.labels, tt.labels) {
+			t.Errorf("niceAxis(%v, %v, %d) labels are %q; want %q", tt.lo, tt.hi, tt.n, a.labels, tt.labels)
+		}
+		if a.lo > tt.lo || a.hi < tt.hi {
+			t.Errorf("niceAxis(%v, %v, %d) covers [%v, %v]", tt.lo, tt.hi, tt.n, a.lo, a.hi)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var p Plot
+	p.Histogram("", []float64{0, 1, 1, 2, 3, 3, 3, 4}, 4, nil)
+	s := p.series[0]
+	if want := []float64{1, 2, 1, 4}; !reflect.DeepEqual(s.ys, want) {
+		t.Errorf("counts are %v; want %v", s.ys, want)
+	}
+	if want := []float64{0.5, 1.5, 2.5, 3.5}; !reflect.DeepEqual(s.xs, want) {
+		t.Errorf("bins are centered at %v; want %v", s.xs, want)
+	}
+
+	p = Plot{}
+	p.Histogram("", []float64{0, math.NaN(), 1, math.Inf(1), math.Inf(-1), 1}, 2, nil)
+	if want := []float64{1, 2}; !reflect.DeepEqual(p.series[0].ys, want) {
+		t.Errorf("counts with values that are not finite are %v; want %v", p.series[0].ys, want)
+	}
+
+	p = Plot{}
+	p.Histogram("", make([]float64, 9), 0, nil)
+	if n := len(p.series[0].xs); n != 3 {
+		t.Errorf("default number of bins for 9 values is %d; want 3", n)
+	}
+}
+
+func samplePlot() *Plot {
+	p := &Plot{Title: "Sample", XLabel: "time", YLabel: "value", Width: 320, Height: 200}
+	p.Line("up", []float64{0, 1, 2, 3}, []float64{0, 2, 4, 6}, nil)
+	p.Scatter("down", []float64{0, 1, 2, 3}, []float64{6, 4, 2, 0}, color.RGBA{0xff, 0, 0, 0xff})
+	return p
+}
+
+func TestImage(t *testing.T) {
+	m, err := samplePlot().Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Bounds(), image.Rect(0, 0, 320, 200); got != want {
+		t.Fatalf("image bounds are %v; want %v", got, want)
+	}
+	imgtest.Check(t, "testdata/sample.png", m)
+
+	var p Plot
+	p.Bars("", []string{"a", "b"}, []float64{1, 2}, nil)
+	m, err = p.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.Bounds(), image.Rect(0, 0, defaultWidth, defaultHeight); got != want {
+		t.Errorf("default image bounds are %v; want %v", got, want)
+	}
+	if got := color.NRGBAModel.Convert(m.At(0, 0)); got != color.NRGBAModel.Convert(color.White) {
+		t.Errorf("default background is %v; want white", got)
+	}
+}
+
+func TestBadPlots(t *testing.T) {
+	var empty Plot
+	mismatch := Plot{}
+	mismatch.Line("bad", []float64{1, 2}, []float64{1}, nil)
+	small := *samplePlot()
+	small.Width, small.Height = 20, 20
+
+	for name, p := range map[string]*Plot{"empty": &empty, "mismatch": &mismatch, "small": &small} {
+		if _, err := p.Image(); err == nil {
+			t.Errorf("%s: expected error drawing image", name)
+		}
+	}
+	if _, err := samplePlot().Text(8, 3); err == nil {
+		t.Errorf("expected error drawing text in 8x3 characters")
+	}
+}
+
+func TestText(t *testing.T) {
+	var p Plot
+	p.Title = "Quarters"
+	p.Bars("", []string{"Q1", "Q2", "Q3"}, []float64{1, 3, 2}, nil)
+	text, err := p.Text(40, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if len(lines) != 12 {
+		t.Errorf("text has %d lines; want 12:\n%s", len(lines), text)
+	}
+	if !strings.Contains(lines[0], "Quarters") {
+		t.Errorf("first line %q should be the title", lines[0])
+	}
+	if got := lines[len(lines)-1]; strings.Fields(got)[0] != "Q1" || !strings.Contains(got, "Q3") {
+		t.Errorf("last line %q should have the categories", got)
+	}
+	if !strings.Contains(lines[len(lines)-2], "└") {
+		t.Errorf("x axis missing in %q", lines[len(lines)-2])
+	}
+	if !strings.Contains(text, "⣿") {
+		t.Errorf("bars missing in:\n%s", text)
+	}
+	for _, l := range lines {
+		if n := len([]rune(l)); n > 40 {
+			t.Errorf("line %q is %d characters wide; want at most 40", l, n)
+		}
+	}
+}
+
+func TestSmallPlots(t *testing.T) {
+	// Small plots have room for a single tick, but still need two.
+	for _, size := range []image.Point{{15, 10}, {19, 20}, {9, 9}, {25, 4}, {80, 5}} {
+		text, err := samplePlot().Text(size.X, size.Y)
+		if err != nil {
+			if !strings.Contains(err.Error(), "too small") {
+				t.Errorf("%v: unexpected error %v", size, err)
+			}
+			continue
+		}
+		if !strings.ContainsAny(text, "⡀⠋⠁⡴⣠") {
+			t.Errorf("%v: data missing in:\n%s", size, text)
+		}
+	}
+
+	p := samplePlot()
+	p.Width, p.Height = 100, 100
+	m, err := p.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := color.NRGBAModel.Convert(color.RGBA{0xff, 0, 0, 0xff})
+	found := false
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y && !found; y++ {
+		for x := b.Min.X; x < b.Max.X && !found; x++ {
+			found = color.NRGBAModel.Convert(m.At(x, y)) == red
+		}
+	}
+	if !found {
+		t.Errorf("the points are missing in a 100x100 image")
+	}
+}
+
+func TestShow(t *testing.T) {
+	defer func(f func() bool) { isSupported = f }(isSupported)
+	defer func(f func(io.Writer) (*imgcat.Encoder, error)) { newEncoder = f }(newEncoder)
+	defer func() { os.Unsetenv("TMUX_TEST") }()
+	os.Setenv("TMUX_TEST", "false")
+
+	var buf bytes.Buffer
+	isSupported = func() bool { return true }
+	newEncoder = func(w io.Writer) (*imgcat.Encoder, error) { return imgcat.NewProtocolEncoder(w, imgcat.ITerm2) }
+	if err := samplePlot().Show(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "\x1b]1337;File=") {
+		t.Errorf("expected an image, got %q", buf.String())
+	}
+
+	buf.Reset()
+	isSupported = func() bool { return false }
+	if err := samplePlot().Show(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Sample") || !strings.Contains(buf.String(), "└") {
+		t.Errorf("expected the plot as text, got:\n%s", buf.String())
+	}
+
+	// Files that are not terminals get the default width.
+	f, err := ioutil.TempFile("", "plot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := samplePlot().Show(f); err != nil {
+		t.Fatal(err)
+	}
+	text, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(string(text), "\n") {
+		if n := len([]rune(l)); n > 80 {
+			t.Errorf("line %q is %d characters wide; want at most 80", l, n)
+		}
+	}
+}
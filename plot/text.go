@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc. All rights reserved.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to writing, software distributed
+// under the License is distributed on a "AS IS" BASIS, WITHOUT WARRANTIES OR
+// CONDITIONS OF ANY KIND, either express or implied.
+//
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plot
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// brailleCanvas draws dots in a grid of braille characters, each of them
+// two dots wide and four dots tall.
+type brailleCanvas struct {
+	cols, rows int
+	cells      []rune
+}
+
+func newBrailleCanvas(cols, rows int) *brailleCanvas {
+	c := &brailleCanvas{cols: cols, rows: rows, cells: make([]rune, cols*rows)}
+	for i := range c.cells {
+		c.cells[i] = 0x2800
+	}
+	return c
+}
+
+// Bits of the braille dots, indexed by row and column within a character.
+var brailleDots = [4][2]rune{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+func (c *brailleCanvas) size() (int, int) { return 2 * c.cols, 4 * c.rows }
+
+func (c *brailleCanvas) set(x, y int, _ color.Color) {
+	if x < 0 || y < 0 || x >= 2*c.cols || y >= 4*c.rows {
+		return
+	}
+	c.cells[y/4*c.cols+x/2] |= brailleDots[y%4][x%2]
+}
+
+func (c *brailleCanvas) row(i int) string { return string(c.cells[i*c.cols : (i+1)*c.cols]) }
+
+// Text draws the plot with braille characters, in the given number of
+// columns and rows of text including the axes and titles. Colors are
+// ignored.
+func (p *Plot) Text(cols, rows int) (string, error) {
+	x, y, err := p.axes(cols/10, rows/3)
+	if err != nil {
+		return "", err
+	}
+
+	// Lines around the plot area for the texts.
+	var header []string
+	if p.Title != "" {
+		header = append(header, center(p.Title, cols))
+	}
+	if p.YLabel != "" {
+		header = append(header, p.YLabel)
+	}
+	footer := 2
+	if p.XLabel != "" {
+		footer++
+	}
+	left := 0
+	for _, l := range y.labels {
+		if n := len([]rune(l)); n > left {
+			left = n
+		}
+	}
+	width, height := cols-left-1, rows-len(header)-footer
+	if width < 4 || height < 2 {
+		return "", fmt.Errorf("%dx%d characters are too small for the plot", cols, rows)
+	}
+
+	cv := newBrailleCanvas(width, height)
+	p.draw(cv, x, y, 1)
+
+	// Labels of the y axis at the rows of their ticks.
+	ylabels := make([]string, height)
+	for i, v := range y.ticks {
+		r := height - 1 - int(math.Round(y.pos(v, 4*height)))/4
+		ylabels[r] = y.labels[i]
+	}
+
+	var buf bytes.Buffer
+	for _, l := range header {
+		fmt.Fprintln(&buf, strings.TrimRight(l, " "))
+	}
+	for r := 0; r < height; r++ {
+		axis := "│"
+		if ylabels[r] != "" {
+			axis = "┤"
+		}
+		fmt.Fprintf(&buf, "%*s%s%s\n", left, ylabels[r], axis, cv.row(r))
+	}
+
+	// The x axis with ticks, and their labels below when they fit.
+	ruler := []rune(strings.Repeat("─", width))
+	labels := []rune(strings.Repeat(" ", cols))
+	free := 0
+	for i, v := range x.ticks {
+		c := int(math.Round(x.pos(v, 2*width))) / 2
+		ruler[c] = '┬'
+		if i >= len(x.labels) {
+			continue
+		}
+		l := []rune(x.labels[i])
+		start := left + 1 + c - len(l)/2
+		if start < free || start+len(l) > cols {
+			continue
+		}
+		copy(labels[start:], l)
+		free = start + len(l) + 1
+	}
+	fmt.Fprintf(&buf, "%s└%s\n", strings.Repeat(" ", left), string(ruler))
+	fmt.Fprintln(&buf, strings.TrimRight(string(labels), " "))
+	if p.XLabel != "" {
+		fmt.Fprintln(&buf, strings.TrimRight(center(p.XLabel, cols), " "))
+	}
+	return buf.String(), nil
+}
+
+// center returns s preceded by the spaces that center it in n columns.
+func center(s string, n int) string {
+	pad := (n - len([]rune(s))) / 2
+	if pad < 0 {
+		pad = 0
+	}
+	return strings.Repeat(" ", pad) + s
+}